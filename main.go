@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
 
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -11,18 +14,62 @@ import (
 )
 
 var (
-	authToken  = kingpin.Flag("auth", "Auth token").String()
-	teams      = kingpin.Flag("team", "Team names").Required().Strings()
-	pdTeams    = kingpin.Flag("pd-team", "Team names in PagerDuty if different from Team").Strings()
-	since      = kingpin.Flag("since", "Since date/time").Required().String()
-	until      = kingpin.Flag("until", "Until date/time").Required().String()
-	urgency    = kingpin.Flag("urgency", "Urgency").Default("high").String()
-	replace    = kingpin.Flag("replace", "Replace titles with regex").Strings()
-	tagFilters = kingpin.Flag("tags", "Filter PagerDuty incidents by Datadog tags").Strings()
+	authToken      = kingpin.Flag("auth", "Auth token").String()
+	teams          = kingpin.Flag("team", "Team names").Required().Strings()
+	pdTeams        = kingpin.Flag("pd-team", "Team names in the paging tool if different from Team").Strings()
+	since          = kingpin.Flag("since", "Since date/time").Required().String()
+	until          = kingpin.Flag("until", "Until date/time").Required().String()
+	urgency        = kingpin.Flag("urgency", "Urgency").Default("high").PreAction(setByUser(&urgencySet)).String()
+	replace        = kingpin.Flag("replace", "Replace titles with regex").Strings()
+	tagFilters     = kingpin.Flag("tags", "Only keep pages carrying one of these tags, in the form name:value").Strings()
+	pager          = kingpin.Flag("pager", "Paging tool to fetch pages from").Default("pagerduty").Enum("pagerduty", "opsgenie", "prometheus", "grafana-oncall")
+	opsgenieURL    = kingpin.Flag("opsgenie-url", "Opsgenie API URL, e.g. https://api.eu.opsgenie.com for EU accounts").Default("https://api.opsgenie.com").String()
+	promURL        = kingpin.Flag("prometheus-url", "Prometheus URL to fetch the history of alerts from, e.g. http://prometheus:9090").String()
+	promMatchers   = kingpin.Flag("prometheus-filter", "PromQL label matchers selecting the alerts of the team, e.g. team=\"my-team\"").Strings()
+	oncallURL      = kingpin.Flag("grafana-oncall-url", "Grafana OnCall API URL").String()
+	incidentsFrom  = kingpin.Flag("incidents", "Incident management tool to fetch incidents from").Default("datadog").Enum("datadog", "incidentio", "firehydrant")
+	ddSite         = kingpin.Flag("dd-site", "Datadog site, e.g. datadoghq.eu").Envar("DD_SITE").Default("datadoghq.com").String()
+	ddPageSize     = kingpin.Flag("dd-page-size", "Number of Datadog incidents to fetch per page").Default("50").Int()
+	ddMaxIncidents = kingpin.Flag("dd-max-incidents", "Maximum number of Datadog incidents to fetch").Default("1000").Int()
+	concurrency    = kingpin.Flag("concurrency", "Number of PagerDuty incidents to fetch details for concurrently").Default("4").Int()
+	userCache      = kingpin.Flag("pd-user-cache", "File caching PagerDuty users across runs").String()
+	userCacheTTL   = kingpin.Flag("pd-user-cache-ttl", "How long the PagerDuty user cache stays valid").Default("24h").Duration()
+	format         = kingpin.Flag("format", "Output format of the report").Default("markdown").Enum("markdown", "json", "html", "csv")
+	templatePath   = kingpin.Flag("template", "Go text/template file to render the markdown report with").String()
+	mergePath      = kingpin.Flag("merge", "Previously generated markdown report to keep the sections filled out by the team from").String()
+	mergePage      = kingpin.Flag("merge-confluence", "Keep the sections filled out by the team from the Confluence page the report is uploaded to").Bool()
+	incidentsCSV   = kingpin.Flag("incidents-csv", "Also write the incidents of the report as CSV to this file").String()
+	corrLead       = kingpin.Flag("correlation-lead", "Associate pages fired up to this long before an incident was declared").Default("15m").Duration()
+	corrGrace      = kingpin.Flag("correlation-grace", "Associate pages fired up to this long after an incident was resolved").Default("0s").Duration()
+	corrServices   = kingpin.Flag("correlation-services", "Only associate pages with incidents sharing a service or a tag with them, on by default when reporting on several teams").PreAction(setByUser(&corrServicesSet)).Bool()
+	corrMapping    = kingpin.Flag("correlation-mapping", "JSON file mapping PagerDuty services and alert tags to the services and tags of incidents, implies --correlation-services").String()
 	// Params for uploading the report
 	subdomain = kingpin.Flag("confluence-subdomain", "Confluence subdomain").String()
 	spaceKey  = kingpin.Flag("confluence-space", "Confluence space key").String()
 	parentId  = kingpin.Flag("confluence-parent", "Confluence parent page id").String()
+	pageId    = kingpin.Flag("confluence-page-id", "Id of the Confluence page to update, instead of the page with the same title").String()
+	native    = kingpin.Flag("confluence-native", "Render the page natively in Confluence storage format, with status lozenges, expandable incidents and user mentions").Bool()
+	confUsers = kingpin.Flag("confluence-users", "JSON file mapping emails to Confluence account ids, to mention incident commanders and responders in native pages").String()
+	// Params for posting a summary to Slack
+	slackWebhook = kingpin.Flag("slack-webhook", "Slack incoming webhook URL to post a summary of the report to").Envar("SLACK_WEBHOOK_URL").String()
+	slackChannel = kingpin.Flag("slack-channel", "Slack channel to post a summary of the report to, using SLACK_BOT_TOKEN").String()
+	slackApiURL  = kingpin.Flag("slack-api-url", "Slack Web API URL").Default("https://slack.com/api").String()
+	// Params for emailing the report
+	smtpHost     = kingpin.Flag("smtp-host", "SMTP server to email the report through").String()
+	smtpPort     = kingpin.Flag("smtp-port", "SMTP server port").Default("587").Int()
+	smtpStartTLS = kingpin.Flag("smtp-starttls", "Use STARTTLS with the SMTP server").Default("true").Bool()
+	emailFrom    = kingpin.Flag("email-from", "Sender of the report email").String()
+	emailTo      = kingpin.Flag("email-to", "Recipients of the report email, either for all teams or for a single team in the form team=a@example.com,b@example.com").Strings()
+	// Params for filing follow-ups
+	jiraURL       = kingpin.Flag("jira-url", "Jira URL to file follow-up issues in, e.g. https://my-company.atlassian.net").String()
+	jiraProject   = kingpin.Flag("jira-project", "Key of the Jira project to file follow-up issues in").String()
+	jiraIssueType = kingpin.Flag("jira-issue-type", "Type of the Jira follow-up issues").Default("Task").String()
+	jiraCreate    = kingpin.Flag("jira-create", "File the missing follow-up issues in Jira, instead of only linking the ones filed by previous runs").Bool()
+	githubRepo    = kingpin.Flag("github-repo", "GitHub repository to file follow-up issues in, e.g. my-org/my-repo").String()
+	githubLabel   = kingpin.Flag("github-label", "Label of the GitHub follow-up issues").Default("incidentist").String()
+	githubApiURL  = kingpin.Flag("github-api-url", "GitHub API URL, e.g. https://github.example.com/api/v3 for GitHub Enterprise").Default("https://api.github.com").String()
+	// Overall deadline of the run
+	timeout = kingpin.Flag("timeout", "Abort if generating and uploading the report takes longer than this, e.g. 10m").Duration()
 )
 
 func errorf(format string, a ...interface{}) {
@@ -34,6 +81,54 @@ func exit(format string, a ...interface{}) {
 	os.Exit(-1)
 }
 
+// urgencySet tells whether --urgency was given, rather than defaulted
+var urgencySet bool
+
+// corrServicesSet tells whether --correlation-services or --no-correlation-services was given, rather than defaulted
+var corrServicesSet bool
+
+// setByUser returns a flag action recording that the flag was given
+func setByUser(set *bool) kingpin.Action {
+	return func(*kingpin.ParseContext) error {
+		*set = true
+		return nil
+	}
+}
+
+// pagerTeams returns the team names to use in the paging tool
+func pagerTeams() []string {
+	if len(*pdTeams) > 0 {
+		return *pdTeams
+	}
+	return *teams
+}
+
+// emailRecipients returns the recipients of the report email: those given for all teams, and those given for one of the teams of the report
+func emailRecipients() []string {
+	var recipients []string
+	for _, spec := range *emailTo {
+		if i := strings.Index(spec, "="); i >= 0 {
+			team := strings.ToLower(strings.TrimSpace(spec[:i]))
+			found := false
+			for _, t := range *teams {
+				if t == team {
+					found = true
+				}
+			}
+			if !found {
+				continue
+			}
+			spec = spec[i+1:]
+		}
+		for _, to := range strings.Split(spec, ",") {
+			if to = strings.TrimSpace(to); to != "" {
+				recipients = append(recipients, to)
+			}
+		}
+	}
+	return recipients
+}
+
 func main() {
 	kingpin.Parse()
 
@@ -41,69 +136,358 @@ func main() {
 		(*teams)[i] = strings.ToLower(team)
 	}
 
-	if *authToken == "" {
-		*authToken = os.Getenv("PD_AUTH_TOKEN")
-	}
+	var pageSource report.PageSource
+	switch *pager {
+	case "pagerduty":
+		if *authToken == "" {
+			*authToken = os.Getenv("PD_AUTH_TOKEN")
+		}
 
-	if *authToken == "" {
-		exit("missing auth token (--auth or PD_AUTH_TOKEN)")
-	}
+		if *authToken == "" {
+			exit("missing auth token (--auth or PD_AUTH_TOKEN)")
+		}
+	case "opsgenie":
+		opsgenieApiKey := os.Getenv("OPSGENIE_API_KEY")
+		if opsgenieApiKey == "" {
+			exit("missing opsgenie api key (OPSGENIE_API_KEY)")
+		}
+
+		pageSource = &report.OpsgeniePageSource{
+			Teams:       pagerTeams(),
+			TagFilters:  *tagFilters,
+			ApiKey:      opsgenieApiKey,
+			Urgency:     *urgency,
+			Replace:     *replace,
+			Concurrency: *concurrency,
+			ApiURL:      *opsgenieURL,
+		}
+	case "prometheus":
+		if urgencySet {
+			exit("--urgency is not supported with --pager prometheus")
+		}
+		if *promURL == "" {
+			exit("missing prometheus url (--prometheus-url)")
+		}
+
+		pageSource = &report.PrometheusPageSource{
+			URL:           *promURL,
+			Matchers:      *promMatchers,
+			TagFilters:    *tagFilters,
+			Replace:       *replace,
+			Authorization: os.Getenv("PROMETHEUS_AUTHORIZATION"),
+		}
+	case "grafana-oncall":
+		if urgencySet {
+			exit("--urgency is not supported with --pager grafana-oncall")
+		}
+		if *oncallURL == "" {
+			exit("missing grafana oncall url (--grafana-oncall-url)")
+		}
+		oncallToken := os.Getenv("GRAFANA_ONCALL_TOKEN")
+		if oncallToken == "" {
+			exit("missing grafana oncall token (GRAFANA_ONCALL_TOKEN)")
+		}
 
-	ddApiKey := os.Getenv("DD_API_KEY")
-	if ddApiKey == "" {
-		exit("missing datadog api key (DD_API_KEY)")
+		pageSource = &report.GrafanaOnCallPageSource{
+			URL:         *oncallURL,
+			Token:       oncallToken,
+			Teams:       pagerTeams(),
+			TagFilters:  *tagFilters,
+			Replace:     *replace,
+			Concurrency: *concurrency,
+		}
 	}
 
-	ddAppKey := os.Getenv("DD_APP_KEY")
-	if ddAppKey == "" {
-		exit("missing datadog app key (DD_APP_KEY)")
+	var ddApiKey, ddAppKey string
+	var incidentSource report.IncidentSource
+	switch *incidentsFrom {
+	case "datadog":
+		ddApiKey = os.Getenv("DD_API_KEY")
+		if ddApiKey == "" {
+			exit("missing datadog api key (DD_API_KEY)")
+		}
+
+		ddAppKey = os.Getenv("DD_APP_KEY")
+		if ddAppKey == "" {
+			exit("missing datadog app key (DD_APP_KEY)")
+		}
+	case "incidentio":
+		incidentIoApiKey := os.Getenv("INCIDENT_IO_API_KEY")
+		if incidentIoApiKey == "" {
+			exit("missing incident.io api key (INCIDENT_IO_API_KEY)")
+		}
+
+		incidentSource = &report.IncidentIoIncidentSource{
+			ApiKey: incidentIoApiKey,
+			Teams:  *teams,
+		}
+	case "firehydrant":
+		fireHydrantApiKey := os.Getenv("FIREHYDRANT_API_KEY")
+		if fireHydrantApiKey == "" {
+			exit("missing firehydrant api key (FIREHYDRANT_API_KEY)")
+		}
+
+		incidentSource = &report.FireHydrantIncidentSource{
+			ApiKey: fireHydrantApiKey,
+			Teams:  *teams,
+		}
 	}
 
-	var confUsername, confToken string
+	var uploadRequest report.UploadRequest
 	doUpload := *subdomain != ""
 	if doUpload {
 		// Only check these credentials if we want to upload to confluence
-		confUsername = os.Getenv("CONFLUENCE_USERNAME")
+		confUsername := os.Getenv("CONFLUENCE_USERNAME")
 		if confUsername == "" {
 			exit("missing confluence username (CONFLUENCE_USERNAME)")
 		}
 
-		confToken = os.Getenv("CONFLUENCE_API_TOKEN")
+		confToken := os.Getenv("CONFLUENCE_API_TOKEN")
 		if confToken == "" {
 			exit("missing confluence auth token (CONFLUENCE_API_TOKEN)")
 		}
 		if *spaceKey == "" {
 			exit("missing space key (--confluence-space)")
 		}
+		if *format != "markdown" {
+			exit("only markdown reports can be uploaded (--format markdown)")
+		}
+
+		uploadRequest = report.UploadRequest{
+			ConfluenceSubdomain: *subdomain,
+			ConfluenceUsername:  confUsername,
+			ConfluenceToken:     confToken,
+			SpaceKey:            *spaceKey,
+			ParentId:            *parentId,
+			PageId:              *pageId,
+		}
+		if *confUsers != "" {
+			if !*native {
+				exit("mentions require the page to be rendered natively (--confluence-native)")
+			}
+			accountIDs, err := report.ReadConfluenceAccountIds(*confUsers)
+			if err != nil {
+				exit("error reading confluence users: %v", err)
+			}
+			uploadRequest.AccountIDs = accountIDs
+		}
+	}
+	var slackToken string
+	if *slackChannel != "" && *slackWebhook == "" {
+		slackToken = os.Getenv("SLACK_BOT_TOKEN")
+		if slackToken == "" {
+			exit("missing slack bot token (SLACK_BOT_TOKEN)")
+		}
+	}
+
+	doEmail := *smtpHost != ""
+	if doEmail {
+		if *emailFrom == "" {
+			exit("missing email sender (--email-from)")
+		}
+		if len(emailRecipients()) == 0 {
+			exit("missing email recipients for teams %s (--email-to)", strings.Join(*teams, ", "))
+		}
+	}
+
+	var jiraUsername, jiraToken string
+	if *jiraURL != "" {
+		jiraUsername = os.Getenv("JIRA_USERNAME")
+		if jiraUsername == "" {
+			exit("missing jira username (JIRA_USERNAME)")
+		}
+		jiraToken = os.Getenv("JIRA_API_TOKEN")
+		if jiraToken == "" {
+			exit("missing jira api token (JIRA_API_TOKEN)")
+		}
+		if *jiraProject == "" {
+			exit("missing jira project (--jira-project)")
+		}
+	}
+
+	// Action items are annotated with a single issue, which the second tracker would take for its own and never file
+	if *jiraURL != "" && *githubRepo != "" {
+		exit("follow-ups can be filed either in jira (--jira-url) or in github (--github-repo), not both")
+	}
+
+	var githubToken string
+	if *githubRepo != "" {
+		githubToken = os.Getenv("GITHUB_TOKEN")
+		if githubToken == "" {
+			exit("missing github token (GITHUB_TOKEN)")
+		}
+	}
+
+	if *mergePage && !doUpload {
+		exit("merging requires the report to be uploaded (--confluence-subdomain)")
+	}
+	// Filled out sections are found by the headings of the built-in template, a custom one would silently lose them
+	if *templatePath != "" && (*mergePath != "" || *mergePage) {
+		exit("merging previous reports only works with the built-in template, not with --template")
+	}
+
+	// Incidents of several teams often overlap, only the services of their pages tell them apart
+	matchServices := len(*teams) > 1 || *corrMapping != ""
+	if corrServicesSet {
+		matchServices = *corrServices
+	}
+	correlation := &report.CorrelationPolicy{
+		LeadWindow:    *corrLead,
+		GracePeriod:   *corrGrace,
+		MatchServices: matchServices,
+	}
+	if *corrMapping != "" {
+		mapping, err := report.ReadCorrelationMapping(*corrMapping)
+		if err != nil {
+			exit("error reading correlation mapping: %v", err)
+		}
+		correlation.Mapping = mapping
 	}
 
 	generateRequest := report.GenerateRequest{
-		Teams:      *teams,
-		PdTeams:    *pdTeams,
-		Since:      *since,
-		Until:      *until,
-		TagFilters: *tagFilters,
-		AuthToken:  *authToken,
-		Urgency:    *urgency,
-		Replace:    *replace,
-		DdApiKey:   ddApiKey,
-		DdAppKey:   ddAppKey,
+		Teams:          *teams,
+		PdTeams:        *pdTeams,
+		Since:          *since,
+		Until:          *until,
+		TagFilters:     *tagFilters,
+		AuthToken:      *authToken,
+		Urgency:        *urgency,
+		Replace:        *replace,
+		DdApiKey:       ddApiKey,
+		DdAppKey:       ddAppKey,
+		DdSite:         *ddSite,
+		DdPageSize:     *ddPageSize,
+		DdMaxIncidents: *ddMaxIncidents,
+		Concurrency:    *concurrency,
+		UserCachePath:  *userCache,
+		UserCacheTTL:   *userCacheTTL,
+		IncidentSource: incidentSource,
+		PageSource:     pageSource,
+		Correlation:    correlation,
+	}
+
+	// Cancel all requests on Ctrl+C or when the timeout expires
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
 	}
 
-	content, err := report.Generate(generateRequest)
+	rep, err := report.BuildReport(ctx, generateRequest)
 
-	if err != nil {
+	// A report with partial data is still worth publishing, but the run has to be flagged as failed
+	var partialErr *report.PartialDataError
+	if err != nil && !errors.As(err, &partialErr) {
 		exit("error generating report: %v", err)
-	} else if doUpload {
-		uploadRequest := report.UploadRequest{
-			ConfluenceSubdomain: *subdomain,
-			ConfluenceUsername:  confUsername,
-			ConfluenceToken:     confToken,
-			SpaceKey:            *spaceKey,
-			ParentId:            *parentId,
-			MarkdownContent:     content,
+	}
+
+	// Keep what the team typed into the previous version of the report
+	var previous string
+	if *mergePath != "" {
+		data, err := os.ReadFile(*mergePath)
+		if err != nil {
+			exit("error reading previous report: %v", err)
+		}
+		previous = string(data)
+	} else if *mergePage {
+		previous, err = report.DownloadMarkdown(ctx, uploadRequest, rep.Title)
+		if err != nil {
+			exit("error downloading previous report: %v", err)
+		}
+	}
+	if previous != "" {
+		for _, s := range rep.MergeMarkdown(previous) {
+			errorf("WARN: %s was filled out in the previous report but is no longer part of it", s)
+		}
+	}
+
+	if *jiraURL != "" {
+		unfiled, err := report.CreateJiraIssues(ctx, report.JiraRequest{
+			URL:       *jiraURL,
+			Username:  jiraUsername,
+			Token:     jiraToken,
+			Project:   *jiraProject,
+			IssueType: *jiraIssueType,
+			Create:    *jiraCreate,
+			Report:    rep,
+		})
+		if err != nil {
+			exit("error filing jira issues: %v", err)
+		}
+		for _, title := range unfiled {
+			errorf("WARN: no jira issue for %q yet, rerun with --jira-create to file it", title)
+		}
+	}
+
+	if *githubRepo != "" {
+		err := report.CreateGitHubIssues(ctx, report.GitHubRequest{
+			Repo:   *githubRepo,
+			Label:  *githubLabel,
+			Token:  githubToken,
+			ApiURL: *githubApiURL,
+			Report: rep,
+		})
+		if err != nil {
+			exit("error filing github issues: %v", err)
+		}
+	}
+
+	var content string
+	switch *format {
+	case "markdown":
+		if *templatePath == "" {
+			content, err = rep.Markdown()
+			if err != nil {
+				exit("error rendering report: %v", err)
+			}
+			break
+		}
+		text, err := os.ReadFile(*templatePath)
+		if err != nil {
+			exit("error reading template: %v", err)
+		}
+		content, err = rep.RenderTemplate(string(text))
+		if err != nil {
+			exit("error rendering template: %v", err)
+		}
+	case "json":
+		data, err := rep.JSON()
+		if err != nil {
+			exit("error rendering report: %v", err)
+		}
+		content = string(data)
+	case "html":
+		content, err = rep.HTML()
+		if err != nil {
+			exit("error rendering report: %v", err)
+		}
+	case "csv":
+		data, err := rep.PagesCSV()
+		if err != nil {
+			exit("error rendering report: %v", err)
+		}
+		content = strings.TrimSuffix(string(data), "\n")
+	}
+
+	if *incidentsCSV != "" {
+		data, err := rep.IncidentsCSV()
+		if err != nil {
+			exit("error rendering incidents: %v", err)
+		}
+		if err := os.WriteFile(*incidentsCSV, data, 0o644); err != nil {
+			exit("error writing incidents: %v", err)
 		}
-		err = report.Upload(uploadRequest)
+	}
+
+	var reportURL string
+	if doUpload {
+		uploadRequest.MarkdownContent = content
+		if *native {
+			uploadRequest.Report = rep
+		}
+		reportURL, err = report.UploadPage(ctx, uploadRequest)
 		if err != nil {
 			exit("error uploading report: %v", err)
 		} else {
@@ -113,4 +497,49 @@ func main() {
 		// If not uploading, just dump to stdout.
 		fmt.Println(content)
 	}
+
+	if *slackWebhook != "" || *slackChannel != "" {
+		permalink, err := report.PostSlackMessage(ctx, report.SlackRequest{
+			WebhookURL: *slackWebhook,
+			Token:      slackToken,
+			Channel:    *slackChannel,
+			ApiURL:     *slackApiURL,
+			Report:     rep,
+			ReportURL:  reportURL,
+		})
+		if err != nil {
+			exit("error posting to slack: %v", err)
+		}
+		// The report itself may be printed to stdout
+		if permalink != "" {
+			fmt.Fprintln(os.Stderr, "Summary posted to Slack:", permalink)
+		}
+	}
+
+	if doEmail {
+		markdown := content
+		if *format != "markdown" {
+			markdown, err = rep.Markdown()
+			if err != nil {
+				exit("error rendering report: %v", err)
+			}
+		}
+		err = report.SendEmail(ctx, report.EmailRequest{
+			Host:            *smtpHost,
+			Port:            *smtpPort,
+			StartTLS:        *smtpStartTLS,
+			Username:        os.Getenv("SMTP_USERNAME"),
+			Password:        os.Getenv("SMTP_PASSWORD"),
+			From:            *emailFrom,
+			To:              emailRecipients(),
+			MarkdownContent: markdown,
+		})
+		if err != nil {
+			exit("error emailing report: %v", err)
+		}
+	}
+
+	if partialErr != nil {
+		exit("report generated with partial data, %v", partialErr)
+	}
 }
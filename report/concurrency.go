@@ -0,0 +1,121 @@
+package report
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultConcurrency is the number of workers used when none is configured
+	defaultConcurrency = 4
+	// maxRateLimitRetries is the number of times a rate limited request is retried before giving up
+	maxRateLimitRetries = 5
+	// defaultRateLimitWait is how long to wait after a rate limited request that didn't specify Retry-After
+	defaultRateLimitWait = time.Second
+)
+
+// forEachConcurrently calls fn for every index in [0, n) using at most concurrency goroutines.
+// Once a call fails, no more calls are started and the first error is returned after the running calls have completed.
+func forEachConcurrently(n, concurrency int, fn func(i int) error) error {
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	if concurrency > n {
+		concurrency = n
+	}
+
+	indices := make(chan int)
+	stop := make(chan struct{})
+	var once sync.Once
+	var firstErr error
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				if err := fn(i); err != nil {
+					once.Do(func() {
+						firstErr = err
+						close(stop)
+					})
+				}
+			}
+		}()
+	}
+
+dispatch:
+	for i := 0; i < n; i++ {
+		select {
+		case indices <- i:
+		case <-stop:
+			break dispatch
+		}
+	}
+	close(indices)
+	wg.Wait()
+	return firstErr
+}
+
+// rateLimitedClient wraps an HTTP client and retries requests rejected with 429 Too Many Requests,
+// waiting for as long as the Retry-After header asks before each retry.
+type rateLimitedClient struct {
+	client *http.Client
+}
+
+func newRateLimitedClient(client *http.Client) *rateLimitedClient {
+	return &rateLimitedClient{client: client}
+}
+
+// Do implements pagerduty.HTTPClient
+func (c *rateLimitedClient) Do(req *http.Request) (*http.Response, error) {
+	wait := defaultRateLimitWait
+	for attempt := 0; ; attempt++ {
+		resp, err := c.client.Do(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt >= maxRateLimitRetries {
+			return resp, err
+		}
+		// Requests with a body can only be sent again if they can be rewound
+		if req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+
+		delay := retryAfter(resp.Header.Get("Retry-After"), wait)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if req.Body != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+		wait *= 2
+	}
+}
+
+// retryAfter parses a Retry-After header, given either in seconds or as an HTTP date.
+// It returns fallback if the header is missing or invalid.
+func retryAfter(header string, fallback time.Duration) time.Duration {
+	if header == "" {
+		return fallback
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		if delay := time.Until(at); delay > 0 {
+			return delay
+		}
+		return 0
+	}
+	return fallback
+}
@@ -0,0 +1,154 @@
+package report
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PagerDuty/go-pagerduty"
+)
+
+func TestForEachConcurrently(t *testing.T) {
+	const n, concurrency = 50, 3
+
+	var running, maxRunning int32
+	var mu sync.Mutex
+	calls := make(map[int]int)
+	err := forEachConcurrently(n, concurrency, func(i int) error {
+		now := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if now <= max || atomic.CompareAndSwapInt32(&maxRunning, max, now) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		calls[i]++
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("forEachConcurrently: %v", err)
+	}
+
+	if maxRunning > concurrency {
+		t.Errorf("got %d concurrent calls, want at most %d", maxRunning, concurrency)
+	}
+	for i := 0; i < n; i++ {
+		if calls[i] != 1 {
+			t.Errorf("got %d calls for %d, want 1", calls[i], i)
+		}
+	}
+}
+
+func TestForEachConcurrentlyStopsOnError(t *testing.T) {
+	boom := errors.New("boom")
+	var calls int32
+	err := forEachConcurrently(100, 2, func(i int) error {
+		atomic.AddInt32(&calls, 1)
+		if i == 5 {
+			return boom
+		}
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	if !errors.Is(err, boom) {
+		t.Errorf("got error %v, want %v", err, boom)
+	}
+	// The calls already handed out complete, no other one starts
+	if calls > 8 {
+		t.Errorf("got %d calls after the error, want the workers to stop", calls)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	fallback := 5 * time.Second
+	tests := []struct {
+		name   string
+		header string
+		min    time.Duration
+		max    time.Duration
+	}{
+		{"missing", "", fallback, fallback},
+		{"seconds", "3", 3 * time.Second, 3 * time.Second},
+		{"zero seconds", "0", 0, 0},
+		{"negative seconds", "-1", fallback, fallback},
+		{"http date", time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat), 8 * time.Second, 10 * time.Second},
+		{"http date in the past", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, 0},
+		{"invalid", "soon", fallback, fallback},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.header, fallback); got < tt.min || got > tt.max {
+			t.Errorf("%s: retryAfter(%q) = %v, want between %v and %v", tt.name, tt.header, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestRateLimitedClient(t *testing.T) {
+	tests := []struct {
+		name         string
+		limited      int32
+		wantAttempts int32
+		wantStatus   int
+	}{
+		{"not limited", 0, 1, http.StatusOK},
+		{"limited twice", 2, 3, http.StatusOK},
+		{"always limited", 100, maxRateLimitRetries + 1, http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if n := atomic.AddInt32(&attempts, 1); n <= tt.limited {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				if body, err := io.ReadAll(r.Body); err != nil || string(body) != "payload" {
+					t.Errorf("got body %q, %v, want the original payload", body, err)
+				}
+			}))
+			defer server.Close()
+
+			req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("payload"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := newRateLimitedClient(http.DefaultClient).Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus || attempts != tt.wantAttempts {
+				t.Errorf("got status %d after %d attempts, want %d after %d", resp.StatusCode, attempts, tt.wantStatus, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestIsRetryablePagerDuty(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{http.StatusBadGateway, true},
+		// Rate limits were already retried by rateLimitedClient
+		{http.StatusTooManyRequests, false},
+		{http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		if got := isRetryable(pagerduty.APIError{StatusCode: tt.status}); got != tt.want {
+			t.Errorf("isRetryable(%d) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package report
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"strings"
+)
+
+//go:embed templates/report.storage.tmpl
+var storageTemplateText string
+
+var storageTemplate = template.Must(template.New("report.storage").Funcs(htmlTemplateFuncs()).Funcs(template.FuncMap{
+	// status renders a severity as a status lozenge, coloured by how severe it is
+	"status": func(severity string) template.HTML {
+		return template.HTML(fmt.Sprintf(`<ac:structured-macro ac:name="status"><ac:parameter ac:name="colour">%s</ac:parameter><ac:parameter ac:name="title">%s</ac:parameter></ac:structured-macro>`,
+			severityColour(severity), template.HTMLEscapeString(severity)))
+	},
+	// placeholder is the text asking the team to fill out a section
+	"placeholder": func() template.HTML {
+		return template.HTML("<em>TODO: please fill out</em>")
+	},
+	// mention is replaced when rendering, once users are resolved
+	"mention": template.HTMLEscapeString,
+}).Parse(storageTemplateText))
+
+// severityColours are the colours of the status lozenges of the severities used by the incident sources, in upper case
+var severityColours = map[string]string{
+	// Datadog
+	"SEV-0": "Red",
+	"SEV-1": "Red",
+	"SEV-2": "Yellow",
+	"SEV-3": "Blue",
+	// FireHydrant
+	"SEV0": "Red",
+	"SEV1": "Red",
+	"SEV2": "Yellow",
+	"SEV3": "Blue",
+	// incident.io
+	"CRITICAL": "Red",
+	"MAJOR":    "Yellow",
+	"MINOR":    "Blue",
+}
+
+// severityColour picks the colour of the status lozenge of a severity, e.g. red for "SEV-1", and grey for unknown or minor severities
+func severityColour(severity string) string {
+	if colour, ok := severityColours[strings.ToUpper(strings.TrimSpace(severity))]; ok {
+		return colour
+	}
+	return "Grey"
+}
+
+// ConfluenceStorage renders the report in Confluence storage format, with a table of contents, a status lozenge per severity and an expand macro per incident.
+// Emails found in accountIDs, e.g. from the incident commander or responders, are rendered as user mentions, and other emails as plain text.
+func (r *Report) ConfluenceStorage(accountIDs map[string]string) (string, error) {
+	t, err := storageTemplate.Clone()
+	if err != nil {
+		return "", err
+	}
+	t.Funcs(template.FuncMap{
+		"mention": func(email string) template.HTML {
+			if id, ok := accountIDs[email]; ok {
+				return template.HTML(fmt.Sprintf(`<ac:link><ri:user ri:account-id="%s"/></ac:link>`, template.HTMLEscapeString(id)))
+			}
+			return template.HTML(template.HTMLEscapeString(email))
+		},
+	})
+
+	var b strings.Builder
+	if err := t.Execute(&b, r); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// ReadConfluenceAccountIds reads the Confluence account ids of users by email from a JSON file, e.g. {"jane@example.com": "5b10ac8d82e05b22cc7d4ef5"}
+// Confluence Cloud hides emails from its user search API, so users can't be looked up by email to be mentioned.
+func ReadConfluenceAccountIds(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var accountIDs map[string]string
+	if err := json.Unmarshal(data, &accountIDs); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return accountIDs, nil
+}
@@ -0,0 +1,147 @@
+package report
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSeverityColour(t *testing.T) {
+	tests := []struct {
+		severity string
+		want     string
+	}{
+		{"SEV-1", "Red"},
+		{"SEV-2", "Yellow"},
+		{"SEV-3", "Blue"},
+		{"SEV-4", "Grey"},
+		{"SEV-11", "Grey"},
+		{"SEV2", "Yellow"},
+		{"Critical", "Red"},
+		{"minor", "Blue"},
+		{"UNKNOWN", "Grey"},
+		{"", "Grey"},
+	}
+	for _, tt := range tests {
+		if got := severityColour(tt.severity); got != tt.want {
+			t.Errorf("severityColour(%q) = %q, want %q", tt.severity, got, tt.want)
+		}
+	}
+}
+
+func TestConfluenceStorageMentions(t *testing.T) {
+	storage, err := goldenReport().ConfluenceStorage(map[string]string{"ic@example.com": "acc-1", "db@example.com": "acc-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<h4>IC: <ac:link><ri:user ri:account-id="acc-1"/></ac:link></h4>`,
+		`<li><strong>Ack'ed by</strong>: oncall@example.com, <ac:link><ri:user ri:account-id="acc-2"/></ac:link></li>`,
+	} {
+		if !strings.Contains(storage, want) {
+			t.Errorf("storage has no %s", want)
+		}
+	}
+}
+
+func TestStorageToMarkdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage string
+		want    string
+	}{
+		{
+			name:    "headings and paragraphs",
+			storage: "<h3>Other Pages</h3>\n<p>Some <strong>bold</strong>, <em>italic</em> and <code>code</code> text &amp; more</p>",
+			want:    "### Other Pages\n\nSome **bold**, _italic_ and `code` text & more\n\n",
+		},
+		{
+			name:    "status lozenges only keep their body",
+			storage: `<h3><ac:structured-macro ac:name="status"><ac:parameter ac:name="colour">Red</ac:parameter><ac:parameter ac:name="title">SEV-1</ac:parameter></ac:structured-macro> <a href="https://dd/1">#incident-1 | Down | 2021-07-20 @10:00:00</a></h3>`,
+			want:    "###  [#incident-1 | Down | 2021-07-20 @10:00:00](https://dd/1)\n\n",
+		},
+		{
+			name:    "user mentions",
+			storage: `<h4>IC: <ac:link><ri:user ri:account-id="acc-1"/></ac:link></h4><p>Paged <ac:link><ri:user ri:account-id="acc-2"/></ac:link> twice</p>`,
+			want:    "#### IC: \n\nPaged  twice\n\n",
+		},
+		{
+			name:    "nested lists",
+			storage: "<ul>\n<li><a href=\"https://pd/1\">Disk full [db-1]</a><ul>\n<li><strong>Notes</strong>:<ul>\n<li>Cleaned up</li>\n</ul></li>\n<li><strong>Action taken</strong>: Freed space</li>\n</ul></li>\n</ul>",
+			want:    "- [Disk full [db-1]](https://pd/1)\n  - **Notes**:\n    - Cleaned up\n  - **Action taken**: Freed space\n\n",
+		},
+		{
+			name:    "line breaks and paragraphs in list items",
+			storage: "<ul><li><strong>Runbooks</strong><br/>https://wiki/runbook</li><li><p>First</p><p>Second</p></li></ul>",
+			want:    "- **Runbooks**\nhttps://wiki/runbook\n- First\n  Second\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := storageToMarkdown(tt.storage)
+			if err != nil {
+				t.Fatalf("storageToMarkdown: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got:\n%q\nwant:\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+// storageReport is the golden report with a page title holding brackets, which natively rendered pages don't escape
+func storageReport() *Report {
+	r := goldenReport()
+	r.OtherPages[0].Title = "Disk full [db-1]"
+	return r
+}
+
+func TestMergeConfluenceStorageRoundTrip(t *testing.T) {
+	withUTC(t)
+
+	storage, err := storageReport().ConfluenceStorage(map[string]string{"ic@example.com": "acc-1", "db@example.com": "acc-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Edit the page the way the Confluence editor saves it
+	filled := fillOut(t, storage,
+		"<h4>Action taken</h4>\n<p><em>TODO: please fill out</em></p>",
+		"<h4>Action taken</h4>\n<p>Rolled back <code>checkout-api</code></p>",
+		"<li><strong>Action taken</strong>: <em>TODO: please fill out</em></li>",
+		"<li><strong>Action taken</strong>: Freed disk space</li>",
+		"<li>Again</li>",
+		"<li>Again</li>\n<li>Added a disk alert</li>",
+	)
+	previous, err := storageToMarkdown(filled)
+	if err != nil {
+		t.Fatalf("storageToMarkdown: %v", err)
+	}
+
+	r := storageReport()
+	if unmerged := r.MergeMarkdown(previous); len(unmerged) != 0 {
+		t.Errorf("got unmerged sections %v, want none", unmerged)
+	}
+	if want := "Rolled back `checkout-api`"; r.Incidents[0].ActionTaken != want {
+		t.Errorf("got action taken %q, want %q", r.Incidents[0].ActionTaken, want)
+	}
+	if r.Incidents[0].FollowUp != "" || r.Incidents[1].ActionTaken != "" {
+		t.Errorf("got follow-up %q and action taken %q for sections left as is, want none", r.Incidents[0].FollowUp, r.Incidents[1].ActionTaken)
+	}
+
+	disk := r.OtherPages[0]
+	if disk.ActionTaken != "Freed disk space" {
+		t.Errorf("got page action taken %q, want %q", disk.ActionTaken, "Freed disk space")
+	}
+	wantNotes := []PageNote{{Content: "Cleaned up /tmp", UserEmail: "db@example.com"}, {Content: "Again"}, {Content: "Added a disk alert"}}
+	if !reflect.DeepEqual(disk.Notes, wantNotes) {
+		t.Errorf("got notes %v, want %v", disk.Notes, wantNotes)
+	}
+
+	// Incidents are still matched by ID without the severity in their heading
+	r = storageReport()
+	r.Incidents = r.Incidents[1:]
+	want := []string{"incident #incident-1"}
+	if got := r.MergeMarkdown(previous); !reflect.DeepEqual(got, want) {
+		t.Errorf("got unmerged sections %v, want %v", got, want)
+	}
+}
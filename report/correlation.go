@@ -0,0 +1,252 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultCorrelationPolicy associates pages fired from 15 minutes before an incident was declared until it was resolved
+var defaultCorrelationPolicy = CorrelationPolicy{
+	LeadWindow: 15 * time.Minute,
+}
+
+var (
+	// pagerdutyLinkRegex matches links to PagerDuty incidents or alerts, capturing whether they are incidents or alerts, and their ID
+	pagerdutyLinkRegex = regexp.MustCompile(`pagerduty\.com/(incidents|alerts)/([A-Z0-9]+)`)
+	// datadogMonitorLinkRegex matches links to Datadog monitors, capturing their ID
+	datadogMonitorLinkRegex = regexp.MustCompile(`/monitors/(\d+)`)
+)
+
+// CorrelationMethod describes how a page was associated with an incident
+type CorrelationMethod string
+
+const (
+	// CorrelatedByPage is used when the incident records the page it was declared from
+	CorrelatedByPage CorrelationMethod = "page"
+	// CorrelatedByMonitor is used when the page was triggered by a monitor the incident records, and fired within the correlation window
+	CorrelatedByMonitor CorrelationMethod = "monitor"
+	// CorrelatedByTime is used when the page fired within the correlation window of the incident, without any explicit link between them
+	CorrelatedByTime CorrelationMethod = "time_window"
+)
+
+// Correlation records how a page was associated with one of its incidents
+type Correlation struct {
+	// ID of the incident
+	IncidentID string `json:"incident_id"`
+	// How the page was associated with the incident
+	Method CorrelationMethod `json:"method"`
+}
+
+// CorrelationPolicy decides which pages are associated with an incident
+type CorrelationPolicy struct {
+	// How long before an incident was declared its pages may have fired
+	LeadWindow time.Duration
+	// How long after an incident was resolved its pages may still fire
+	GracePeriod time.Duration
+	// Only associate pages with incidents they fired during when they share a service or a tag.
+	// Pages or incidents without any service or tag can't be told apart and are still associated.
+	// BuildReport enables it by default when reporting on several teams, whose incidents are likely to overlap.
+	MatchServices bool
+	// Optional mapping of the services and tags of pages to those of incidents, when they are named differently
+	Mapping *CorrelationMapping
+}
+
+// CorrelationMapping maps the services and tags of pages to the services and tags incidents know them as
+type CorrelationMapping struct {
+	// PagerDuty service names to the Datadog services or teams they correspond to
+	Services map[string][]string `json:"services"`
+	// Alert tags to the incident tags they correspond to, e.g. "team:payments" to "teams:checkout"
+	Tags map[string][]string `json:"tags"`
+}
+
+// ReadCorrelationMapping reads a correlation mapping from a JSON file
+func ReadCorrelationMapping(path string) (*CorrelationMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var mapping CorrelationMapping
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return &mapping, nil
+}
+
+// incidentFields returns the names of the incident fields the tags of pages are mapped to, e.g. "teams" for "teams:checkout"
+func (m *CorrelationMapping) incidentFields() []string {
+	if m == nil {
+		return nil
+	}
+	var fields []string
+	for _, tags := range m.Tags {
+		for _, tag := range tags {
+			if n := strings.Index(tag, ":"); n > 0 {
+				fields = appendUnique(fields, tag[:n])
+			}
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// services returns the names incidents may know the service of a page as
+func (m *CorrelationMapping) services(service string) []string {
+	names := []string{service}
+	if m != nil {
+		names = append(names, m.Services[service]...)
+	}
+	return names
+}
+
+// tags returns the tags incidents may know a tag of a page as
+func (m *CorrelationMapping) tags(tag string) []string {
+	tags := []string{tag}
+	if m != nil {
+		tags = append(tags, m.Tags[tag]...)
+	}
+	return tags
+}
+
+// window returns when pages associated with the incident may have fired. Incidents still open are considered open until the end of the report.
+func (c CorrelationPolicy) window(i *Incident, until time.Time) (time.Time, time.Time) {
+	end := until
+	if !i.ResolvedAt.IsZero() {
+		end = i.ResolvedAt.Add(c.GracePeriod)
+	}
+	return i.CreatedAt.Add(-c.LeadWindow), end
+}
+
+// inWindow tells whether the page fired within the correlation window of the incident.
+// The window includes its bounds, so that pages firing exactly LeadWindow before or GracePeriod after the incident count as the flags describe.
+func (c CorrelationPolicy) inWindow(p *Page, i *Incident, until time.Time) bool {
+	start, end := c.window(i, until)
+	return !p.CreatedAt.Before(start) && !p.CreatedAt.After(end)
+}
+
+// matchesServices tells whether the page and the incident share a service or a tag, when the policy requires it
+func (c CorrelationPolicy) matchesServices(p *Page, i *Incident) bool {
+	if !c.MatchServices {
+		return true
+	}
+	if (p.Service == "" && len(p.Tags) == 0) || (len(i.Services) == 0 && len(i.Tags) == 0) {
+		return true
+	}
+
+	if p.Service != "" && containsAnyFold(i.Services, c.Mapping.services(p.Service)) {
+		return true
+	}
+	for _, tag := range p.Tags {
+		if containsAnyFold(i.Tags, c.Mapping.tags(tag)) {
+			return true
+		}
+	}
+	return false
+}
+
+// needsAlerts tells whether correlating pages with the incidents requires the alerts grouped under PagerDuty pages:
+// their IDs and monitors when the incidents record alerts or monitors, or their tags when matching services.
+func (c CorrelationPolicy) needsAlerts(incidents []*Incident) bool {
+	if c.MatchServices {
+		return true
+	}
+	for _, i := range incidents {
+		if len(i.AlertIDs) > 0 || len(i.MonitorIDs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// correlate associates pages with incidents. Pages explicitly linked to incidents, through the page or the monitors the incidents record,
+// are only associated with those. Other pages are associated with every incident they fired during, sharing a service or a tag with it if the policy requires so.
+func correlate(incidents []*Incident, pages []*Page, policy CorrelationPolicy, until time.Time) {
+	for _, p := range pages {
+		explicit := false
+		for _, i := range incidents {
+			if method, ok := explicitCorrelation(p, i, policy, until); ok {
+				associate(p, i, method)
+				explicit = true
+			}
+		}
+		if explicit {
+			continue
+		}
+
+		for _, i := range incidents {
+			if policy.inWindow(p, i, until) && policy.matchesServices(p, i) {
+				associate(p, i, CorrelatedByTime)
+			}
+		}
+	}
+}
+
+// CorrelatedBy returns how the page was associated with the incident with the given ID, or an empty string if it wasn't
+func (p *Page) CorrelatedBy(incidentID string) CorrelationMethod {
+	for _, c := range p.Correlations {
+		if c.IncidentID == incidentID {
+			return c.Method
+		}
+	}
+	return ""
+}
+
+// correlationNote describes how the page was associated with the incident in reports.
+// Pages associated by time alone are the norm, only explicit links are worth pointing out.
+func correlationNote(p *Page, incidentID string) string {
+	switch p.CorrelatedBy(incidentID) {
+	case CorrelatedByPage:
+		return "declared from this page"
+	case CorrelatedByMonitor:
+		return "triggered by a monitor of the incident"
+	default:
+		return ""
+	}
+}
+
+func associate(p *Page, i *Incident, method CorrelationMethod) {
+	i.Pages = append(i.Pages, p)
+	p.IncidentIDs = append(p.IncidentIDs, i.ID)
+	p.Correlations = append(p.Correlations, Correlation{IncidentID: i.ID, Method: method})
+}
+
+// explicitCorrelation tells how the incident records the page, if it does.
+// Monitors keep firing long after an incident, so pages they triggered are only associated within the correlation window.
+func explicitCorrelation(p *Page, i *Incident, policy CorrelationPolicy, until time.Time) (CorrelationMethod, bool) {
+	if (p.ID != "" && contains(i.PageIDs, p.ID)) || containsAny(i.AlertIDs, p.AlertIDs) {
+		return CorrelatedByPage, true
+	}
+	if containsAny(i.MonitorIDs, p.MonitorIDs) && policy.inWindow(p, i, until) {
+		return CorrelatedByMonitor, true
+	}
+	return "", false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(values, wanted []string) bool {
+	for _, w := range wanted {
+		if contains(values, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(values []string, value string) []string {
+	if contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
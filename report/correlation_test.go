@@ -0,0 +1,251 @@
+package report
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExplicitCorrelation(t *testing.T) {
+	policy := CorrelationPolicy{LeadWindow: 15 * time.Minute}
+	until := at(24 * 60)
+	incident := &Incident{
+		ID:         "#incident-1",
+		PageIDs:    []string{"Q1"},
+		AlertIDs:   []string{"A7"},
+		MonitorIDs: []string{"4242"},
+		CreatedAt:  at(0),
+		ResolvedAt: at(60),
+	}
+
+	tests := []struct {
+		name       string
+		page       *Page
+		wantMethod CorrelationMethod
+		wantOK     bool
+	}{
+		{"linked page", &Page{ID: "Q1", CreatedAt: at(-60)}, CorrelatedByPage, true},
+		{"linked page long after", &Page{ID: "Q1", CreatedAt: at(10 * 60)}, CorrelatedByPage, true},
+		{"linked alert", &Page{ID: "Q2", AlertIDs: []string{"A6", "A7"}, CreatedAt: at(10 * 60)}, CorrelatedByPage, true},
+		{"linked monitor within the window", &Page{ID: "Q3", MonitorIDs: []string{"4242"}, CreatedAt: at(-10)}, CorrelatedByMonitor, true},
+		{"linked monitor outside the window", &Page{ID: "Q3", MonitorIDs: []string{"4242"}, CreatedAt: at(-20)}, "", false},
+		{"other monitor", &Page{ID: "Q4", MonitorIDs: []string{"1"}, CreatedAt: at(10)}, "", false},
+		{"no links", &Page{CreatedAt: at(10)}, "", false},
+	}
+	for _, tt := range tests {
+		method, ok := explicitCorrelation(tt.page, incident, policy, until)
+		if method != tt.wantMethod || ok != tt.wantOK {
+			t.Errorf("%s: explicitCorrelation() = %q, %v, want %q, %v", tt.name, method, ok, tt.wantMethod, tt.wantOK)
+		}
+	}
+}
+
+func TestInWindow(t *testing.T) {
+	until := at(24 * 60)
+	resolved := &Incident{CreatedAt: at(0), ResolvedAt: at(60)}
+	open := &Incident{CreatedAt: at(0)}
+
+	tests := []struct {
+		name     string
+		policy   CorrelationPolicy
+		incident *Incident
+		firedAt  time.Time
+		want     bool
+	}{
+		{"just before the lead window", CorrelationPolicy{LeadWindow: 15 * time.Minute}, resolved, at(-15).Add(-time.Second), false},
+		{"at the start of the lead window", CorrelationPolicy{LeadWindow: 15 * time.Minute}, resolved, at(-15), true},
+		{"during the incident", CorrelationPolicy{}, resolved, at(30), true},
+		{"at the resolution", CorrelationPolicy{}, resolved, at(60), true},
+		{"just after the resolution", CorrelationPolicy{}, resolved, at(60).Add(time.Second), false},
+		{"just inside the grace period", CorrelationPolicy{GracePeriod: 10 * time.Minute}, resolved, at(70).Add(-time.Second), true},
+		{"at the end of the grace period", CorrelationPolicy{GracePeriod: 10 * time.Minute}, resolved, at(70), true},
+		{"just outside the grace period", CorrelationPolicy{GracePeriod: 10 * time.Minute}, resolved, at(70).Add(time.Second), false},
+		{"open incident until the end of the report", CorrelationPolicy{GracePeriod: 10 * time.Minute}, open, at(23 * 60), true},
+		{"open incident after the end of the report", CorrelationPolicy{GracePeriod: 10 * time.Minute}, open, until.Add(time.Second), false},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.inWindow(&Page{CreatedAt: tt.firedAt}, tt.incident, until); got != tt.want {
+			t.Errorf("%s: inWindow() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCorrelate(t *testing.T) {
+	declared := &Incident{ID: "#incident-1", PageIDs: []string{"Q1"}, CreatedAt: at(0), ResolvedAt: at(60)}
+	overlapping := &Incident{ID: "#incident-2", CreatedAt: at(0), ResolvedAt: at(60)}
+	linked := &Page{ID: "Q1", CreatedAt: at(10)}
+	other := &Page{ID: "Q2", CreatedAt: at(20)}
+
+	correlate([]*Incident{declared, overlapping}, []*Page{linked, other}, defaultCorrelationPolicy, at(24*60))
+
+	// The linked page only goes to the incident declared from it, although it fired during both
+	if want := []Correlation{{IncidentID: "#incident-1", Method: CorrelatedByPage}}; !reflect.DeepEqual(linked.Correlations, want) {
+		t.Errorf("got correlations %v, want %v", linked.Correlations, want)
+	}
+	want := []Correlation{{IncidentID: "#incident-1", Method: CorrelatedByTime}, {IncidentID: "#incident-2", Method: CorrelatedByTime}}
+	if !reflect.DeepEqual(other.Correlations, want) {
+		t.Errorf("got correlations %v, want %v", other.Correlations, want)
+	}
+	if linked.CorrelatedBy("#incident-2") != "" || other.CorrelatedBy("#incident-2") != CorrelatedByTime {
+		t.Errorf("got %q and %q", linked.CorrelatedBy("#incident-2"), other.CorrelatedBy("#incident-2"))
+	}
+}
+
+func TestMatchesServices(t *testing.T) {
+	incident := &Incident{Services: []string{"checkout"}, Tags: []string{"services:checkout", "service:checkout", "environment:production"}}
+	mapping := &CorrelationMapping{
+		Services: map[string][]string{"Checkout API (prod)": {"checkout"}},
+		Tags:     map[string][]string{"env:prod": {"environment:production"}},
+	}
+
+	tests := []struct {
+		name   string
+		policy CorrelationPolicy
+		page   *Page
+		want   bool
+	}{
+		{"not required", CorrelationPolicy{}, &Page{Service: "search"}, true},
+		{"same service", CorrelationPolicy{MatchServices: true}, &Page{Service: "Checkout"}, true},
+		{"other service", CorrelationPolicy{MatchServices: true}, &Page{Service: "search"}, false},
+		{"same tag", CorrelationPolicy{MatchServices: true}, &Page{Service: "search", Tags: []string{"service:checkout"}}, true},
+		{"other tags", CorrelationPolicy{MatchServices: true}, &Page{Tags: []string{"service:search", "env:prod"}}, false},
+		{"page without service or tag", CorrelationPolicy{MatchServices: true}, &Page{}, true},
+		{"mapped service", CorrelationPolicy{MatchServices: true, Mapping: mapping}, &Page{Service: "Checkout API (prod)"}, true},
+		{"mapped tag", CorrelationPolicy{MatchServices: true, Mapping: mapping}, &Page{Service: "search", Tags: []string{"env:prod"}}, true},
+		{"unmapped service", CorrelationPolicy{MatchServices: true, Mapping: mapping}, &Page{Service: "Search API (prod)"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.matchesServices(tt.page, incident); got != tt.want {
+			t.Errorf("%s: matchesServices() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Incidents without any service or tag can't be told apart
+	if !(CorrelationPolicy{MatchServices: true}).matchesServices(&Page{Service: "search"}, &Incident{}) {
+		t.Error("incident without services or tags not matched")
+	}
+}
+
+func TestCorrelationMapping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mapping.json")
+	data := `{
+		"services": {"Checkout API (prod)": ["checkout", "payments"]},
+		"tags": {"squad:pay": ["teams:payments"], "env:prod": ["environment:production", "env:production"]}
+	}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mapping, err := ReadCorrelationMapping(path)
+	if err != nil {
+		t.Fatalf("ReadCorrelationMapping: %v", err)
+	}
+	if got, want := mapping.services("Checkout API (prod)"), []string{"Checkout API (prod)", "checkout", "payments"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("services() = %v, want %v", got, want)
+	}
+	if got, want := mapping.services("search"), []string{"search"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("services() = %v, want %v", got, want)
+	}
+	if got, want := mapping.tags("squad:pay"), []string{"squad:pay", "teams:payments"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tags() = %v, want %v", got, want)
+	}
+	if got, want := mapping.incidentFields(), []string{"env", "environment", "teams"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("incidentFields() = %v, want %v", got, want)
+	}
+
+	// Without a mapping, pages are only known by their own service and tags
+	var none *CorrelationMapping
+	if got, want := none.services("search"), []string{"search"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("services() = %v, want %v", got, want)
+	}
+	if got, want := none.tags("env:prod"), []string{"env:prod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tags() = %v, want %v", got, want)
+	}
+	if got := none.incidentFields(); got != nil {
+		t.Errorf("incidentFields() = %v, want none", got)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"services": ["checkout"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadCorrelationMapping(path); err == nil {
+		t.Error("expected an error parsing an invalid mapping")
+	}
+	if _, err := ReadCorrelationMapping(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected an error reading a missing mapping")
+	}
+}
+
+func TestNeedsAlerts(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    CorrelationPolicy
+		incidents []*Incident
+		want      bool
+	}{
+		{"no incidents", defaultCorrelationPolicy, nil, false},
+		{"incidents linking pages", defaultCorrelationPolicy, []*Incident{{PageIDs: []string{"Q1"}}}, false},
+		{"incidents linking alerts", defaultCorrelationPolicy, []*Incident{{}, {AlertIDs: []string{"A1"}}}, true},
+		{"incidents linking monitors", defaultCorrelationPolicy, []*Incident{{MonitorIDs: []string{"4242"}}}, true},
+		{"matching services", CorrelationPolicy{MatchServices: true}, []*Incident{{}}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.needsAlerts(tt.incidents); got != tt.want {
+			t.Errorf("%s: needsAlerts() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestCorrelationNotes checks that the reports point out pages explicitly linked to an incident, and only those
+func TestCorrelationNotes(t *testing.T) {
+	withUTC(t)
+
+	r := goldenReport()
+	checkout, latency := r.Incidents[0].Pages[0], r.Incidents[0].Pages[1]
+	checkout.Correlations = []Correlation{{IncidentID: "#incident-1", Method: CorrelatedByPage}}
+	latency.Correlations = []Correlation{{IncidentID: "#incident-1", Method: CorrelatedByMonitor}}
+
+	html, err := r.HTML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := r.ConfluenceStorage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format string
+		report string
+		want   []string
+	}{
+		{"markdown", renderMarkdown(t, r), []string{
+			"- [2021-07-20 @09:55:00 Checkout errors |prod|](https://acme.pagerduty.com/incidents/Q1) _(declared from this page)_\n",
+			"- [2021-07-20 @10:10:00 Checkout latency](https://acme.pagerduty.com/incidents/Q2) _(triggered by a monitor of the incident)_\n",
+		}},
+		{"html", html, []string{
+			`<a href="https://acme.pagerduty.com/incidents/Q1">2021-07-20 @09:55:00 Checkout errors [prod]</a> <em>(declared from this page)</em></li>`,
+			`<a href="https://acme.pagerduty.com/incidents/Q2">2021-07-20 @10:10:00 Checkout latency</a> <em>(triggered by a monitor of the incident)</em></li>`,
+		}},
+		{"storage", storage, []string{
+			`<a href="https://acme.pagerduty.com/incidents/Q1">2021-07-20 @09:55:00 Checkout errors [prod]</a> <em>(declared from this page)</em></li>`,
+			`<a href="https://acme.pagerduty.com/incidents/Q2">2021-07-20 @10:10:00 Checkout latency</a> <em>(triggered by a monitor of the incident)</em></li>`,
+		}},
+	}
+	for _, tt := range tests {
+		for _, want := range tt.want {
+			if !strings.Contains(tt.report, want) {
+				t.Errorf("%s report has no %s", tt.format, want)
+			}
+		}
+	}
+
+	// Pages associated by time alone are rendered as they always were
+	latency.Correlations = []Correlation{{IncidentID: "#incident-1", Method: CorrelatedByTime}}
+	if md := renderMarkdown(t, r); !strings.Contains(md, "- [2021-07-20 @10:10:00 Checkout latency](https://acme.pagerduty.com/incidents/Q2)\n") {
+		t.Errorf("markdown report annotates a page associated by time:\n%s", md)
+	}
+}
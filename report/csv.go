@@ -0,0 +1,86 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PagesCSV renders one row per page of the report, oldest first, so that pages can be analyzed in a spreadsheet
+func (r *Report) PagesCSV() ([]byte, error) {
+	rows := [][]string{{"created_at", "title", "link", "responders", "incident_ids", "urgency"}}
+	for _, p := range r.pages() {
+		rows = append(rows, []string{
+			p.CreatedAt.Format(time.RFC3339),
+			p.Title,
+			p.Link,
+			strings.Join(p.Responders, ", "),
+			strings.Join(p.IncidentIDs, ", "),
+			p.Urgency,
+		})
+	}
+	return writeCSV(rows)
+}
+
+// IncidentsCSV renders one row per incident of the report, oldest first
+func (r *Report) IncidentsCSV() ([]byte, error) {
+	rows := [][]string{{"id", "title", "link", "severity", "commander", "commander_email", "created_at", "resolved_at", "customer_impact_seconds", "pages"}}
+	for _, i := range r.Incidents {
+		resolvedAt := ""
+		if !i.ResolvedAt.IsZero() {
+			resolvedAt = i.ResolvedAt.Format(time.RFC3339)
+		}
+		rows = append(rows, []string{
+			i.ID,
+			i.Title,
+			i.Link,
+			i.Severity,
+			i.Commander,
+			i.CommanderEmail,
+			i.CreatedAt.Format(time.RFC3339),
+			resolvedAt,
+			strconv.FormatInt(int64(i.CustomerImpactDuration/time.Second), 10),
+			strconv.Itoa(len(i.Pages)),
+		})
+	}
+	return writeCSV(rows)
+}
+
+// pages returns every page of the report once, oldest first, including those associated with incidents
+func (r *Report) pages() []*Page {
+	seen := make(map[*Page]struct{})
+	var pages []*Page
+	add := func(p *Page) {
+		if _, ok := seen[p]; ok {
+			return
+		}
+		seen[p] = struct{}{}
+		pages = append(pages, p)
+	}
+
+	for _, i := range r.Incidents {
+		for _, p := range i.Pages {
+			add(p)
+		}
+	}
+	for _, p := range r.OtherPages {
+		add(p)
+	}
+
+	sort.SliceStable(pages, func(i, j int) bool {
+		return pages[i].CreatedAt.Before(pages[j].CreatedAt)
+	})
+	return pages
+}
+
+func writeCSV(rows [][]string) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
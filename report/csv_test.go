@@ -0,0 +1,49 @@
+package report
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCSV(t *testing.T) {
+	shared := &Page{Title: `Checkout "errors", again`, Link: "https://acme.pagerduty.com/incidents/Q1", CreatedAt: at(5), Urgency: "high",
+		Responders: []string{"first@example.com", "second@example.com"}, IncidentIDs: []string{"#incident-1", "#incident-2"}}
+	earlier := &Page{Title: "Checkout latency", Link: "https://acme.pagerduty.com/incidents/Q2", CreatedAt: at(-5), Urgency: "low", IncidentIDs: []string{"#incident-1"}}
+	other := &Page{Title: "Disk full\non db-1", Link: "https://acme.pagerduty.com/incidents/Q3", CreatedAt: at(120)}
+
+	r := &Report{
+		Incidents: []*Incident{
+			{ID: "#incident-1", Title: "Checkout is down", Link: "https://app.datadoghq.com/incidents/1", Severity: "SEV-2", Commander: "Commander, Ina",
+				CommanderEmail: "ic@example.com", CreatedAt: at(0), ResolvedAt: at(60), CustomerImpactDuration: 90 * time.Second, Pages: []*Page{earlier, shared}},
+			{ID: "#incident-2", Title: "Payments are down", Link: "https://app.datadoghq.com/incidents/2", CreatedAt: at(1), Pages: []*Page{shared}},
+		},
+		OtherPages: []*Page{other},
+	}
+
+	pages, err := r.PagesCSV()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pages of several incidents are listed once, with all their incidents
+	wantPages := `created_at,title,link,responders,incident_ids,urgency
+2021-07-20T09:55:00Z,Checkout latency,https://acme.pagerduty.com/incidents/Q2,,#incident-1,low
+2021-07-20T10:05:00Z,"Checkout ""errors"", again",https://acme.pagerduty.com/incidents/Q1,"first@example.com, second@example.com","#incident-1, #incident-2",high
+2021-07-20T12:00:00Z,"Disk full
+on db-1",https://acme.pagerduty.com/incidents/Q3,,,
+`
+	if string(pages) != wantPages {
+		t.Errorf("got pages:\n%s\nwant:\n%s", pages, wantPages)
+	}
+
+	incidents, err := r.IncidentsCSV()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantIncidents := `id,title,link,severity,commander,commander_email,created_at,resolved_at,customer_impact_seconds,pages
+#incident-1,Checkout is down,https://app.datadoghq.com/incidents/1,SEV-2,"Commander, Ina",ic@example.com,2021-07-20T10:00:00Z,2021-07-20T11:00:00Z,90,2
+#incident-2,Payments are down,https://app.datadoghq.com/incidents/2,,,,2021-07-20T10:01:00Z,,0,1
+`
+	if string(incidents) != wantIncidents {
+		t.Errorf("got incidents:\n%s\nwant:\n%s", incidents, wantIncidents)
+	}
+}
@@ -0,0 +1,74 @@
+package report
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DataIssue describes a page or incident for which some data could not be fetched
+type DataIssue struct {
+	// What the issue is about, e.g. the title of a page
+	Subject string `json:"subject"`
+	// Link to the page or incident, if known
+	Link string `json:"link,omitempty"`
+	// What could not be fetched and why
+	Problem string `json:"problem"`
+}
+
+func (i DataIssue) String() string {
+	if i.Link == "" {
+		return fmt.Sprintf("%s: %s", i.Subject, i.Problem)
+	}
+	return fmt.Sprintf("%s (%s): %s", i.Subject, i.Link, i.Problem)
+}
+
+// PartialDataError is returned along with a report when some pages or incidents could only be partially fetched.
+// The report is still usable, and contains a "Data quality" section listing the same issues.
+type PartialDataError struct {
+	Issues []DataIssue
+}
+
+func (e *PartialDataError) Error() string {
+	lines := make([]string, 0, len(e.Issues)+1)
+	lines = append(lines, fmt.Sprintf("%d issue(s) with partial data:", len(e.Issues)))
+	for _, i := range e.Issues {
+		lines = append(lines, "  "+i.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// dataQuality collects data issues from concurrent workers
+type dataQuality struct {
+	mu     sync.Mutex
+	issues []DataIssue
+}
+
+func (q *dataQuality) add(subject, link, format string, a ...interface{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.issues = append(q.issues, DataIssue{
+		Subject: subject,
+		Link:    link,
+		Problem: fmt.Sprintf(format, a...),
+	})
+}
+
+// err returns a *PartialDataError listing the collected issues in a stable order, or nil if there are none
+func (q *dataQuality) err() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.issues) == 0 {
+		return nil
+	}
+
+	issues := append([]DataIssue(nil), q.issues...)
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Link != issues[j].Link {
+			return issues[i].Link < issues[j].Link
+		}
+		return issues[i].Subject < issues[j].Subject
+	})
+	return &PartialDataError{Issues: issues}
+}
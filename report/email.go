@@ -0,0 +1,150 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EmailRequest describes how to send a report by email
+type EmailRequest struct {
+	// Host of the SMTP server
+	Host string
+	// Port of the SMTP server, usually 587 for STARTTLS
+	Port int
+	// Upgrade the connection to TLS with STARTTLS before authenticating
+	StartTLS bool
+	// Optional SMTP username
+	Username string
+	// Password of Username
+	Password string
+	// Sender of the email
+	From string
+	// Recipients of the email
+	To []string
+	// Report to send, rendered in markdown. The title header, if any, is used as the subject
+	MarkdownContent string
+}
+
+// SendEmail sends the report as a multipart email, with the report rendered in HTML and the markdown as a plain text alternative
+func SendEmail(ctx context.Context, request EmailRequest) error {
+	if len(request.To) == 0 {
+		return fmt.Errorf("no recipients")
+	}
+
+	message, err := buildEmail(request)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(request.Host, strconv.Itoa(request.Port))
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %w", addr, err)
+	}
+	// net/smtp doesn't take a context, so bound the whole conversation by its deadline instead
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, request.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error connecting to %s: %w", addr, err)
+	}
+	defer c.Close()
+
+	if request.StartTLS {
+		if err := c.StartTLS(&tls.Config{ServerName: request.Host}); err != nil {
+			return fmt.Errorf("error starting TLS: %w", err)
+		}
+	}
+	if request.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", request.Username, request.Password, request.Host)); err != nil {
+			return fmt.Errorf("error authenticating: %w", err)
+		}
+	}
+
+	if err := c.Mail(request.From); err != nil {
+		return fmt.Errorf("error sending email: %w", err)
+	}
+	for _, to := range request.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("error adding recipient %s: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("error sending email: %w", err)
+	}
+	if _, err := w.Write(message); err != nil {
+		return fmt.Errorf("error sending email: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error sending email: %w", err)
+	}
+	return c.Quit()
+}
+
+// buildEmail renders the MIME message of the email, with the plain text and HTML versions as alternatives
+func buildEmail(request EmailRequest) ([]byte, error) {
+	content, title := pruneMarkdownTitle(request.MarkdownContent)
+	if title == "" {
+		title = fmt.Sprintf("On-Call Report %s", time.Now().Format(time.DateOnly))
+	}
+	html, err := convertMarkdown(content)
+	if err != nil {
+		return nil, fmt.Errorf("error converting markdown: %v", err)
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		// Clients show the last alternative they support, so the richest one goes last
+		{"text/plain; charset=utf-8", content},
+		{"text/html; charset=utf-8", "<html><body>\n" + html + "</body></html>\n"},
+	}
+	for _, p := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", p.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		pw, err := w.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		qw := quotedprintable.NewWriter(pw)
+		if _, err := qw.Write([]byte(p.content)); err != nil {
+			return nil, err
+		}
+		if err := qw.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", request.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(request.To, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", title))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", w.Boundary())
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
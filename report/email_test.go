@@ -0,0 +1,106 @@
+package report
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildEmail(t *testing.T) {
+	message, err := buildEmail(EmailRequest{
+		From:            "oncall@example.com",
+		To:              []string{"team@example.com", "lead@example.com"},
+		MarkdownContent: "---\ntitle: Rapport d'astreinte été\n---\n# Incidents\n\nA line longer than seventy-six characters, which quoted-printable has to wrap softly.\n",
+	})
+	if err != nil {
+		t.Fatalf("buildEmail: %v", err)
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		t.Fatalf("error parsing the email: %v", err)
+	}
+	if from := m.Header.Get("From"); from != "oncall@example.com" {
+		t.Errorf("got From %q", from)
+	}
+	if to := m.Header.Get("To"); to != "team@example.com, lead@example.com" {
+		t.Errorf("got To %q", to)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if err != nil || subject != "Rapport d'astreinte été" {
+		t.Errorf("got Subject %q, %v", subject, err)
+	}
+	if _, err := time.Parse(time.RFC1123Z, m.Header.Get("Date")); err != nil {
+		t.Errorf("got Date %q: %v", m.Header.Get("Date"), err)
+	}
+	if v := m.Header.Get("MIME-Version"); v != "1.0" {
+		t.Errorf("got MIME-Version %q", v)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("got Content-Type %q, %v", m.Header.Get("Content-Type"), err)
+	}
+
+	// The plain text alternative comes first, as clients show the last one they support
+	want := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", "# Incidents\n\nA line longer than seventy-six characters, which quoted-printable has to wrap softly.\n"},
+		{"text/html; charset=utf-8", "<html><body>\n<h1 id=\"incidents\">Incidents</h1>\n<p>A line longer than seventy-six characters, which quoted-printable has to wrap softly.</p>\n</body></html>\n"},
+	}
+	r := multipart.NewReader(m.Body, params["boundary"])
+	for _, w := range want {
+		// multipart.Reader decodes quoted-printable parts transparently and drops the header, so read them raw
+		part, err := r.NextRawPart()
+		if err != nil {
+			t.Fatalf("error reading the %s part: %v", w.contentType, err)
+		}
+		if ct := part.Header.Get("Content-Type"); ct != w.contentType {
+			t.Errorf("got Content-Type %q, want %q", ct, w.contentType)
+		}
+		if cte := part.Header.Get("Content-Transfer-Encoding"); cte != "quoted-printable" {
+			t.Errorf("got Content-Transfer-Encoding %q", cte)
+		}
+		raw, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(string(raw), "\r\n") {
+			if len(line) > 76 {
+				t.Errorf("got a %d characters line, want at most 76: %q", len(line), line)
+			}
+		}
+		content, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(raw)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.ReplaceAll(string(content), "\r\n", "\n"); got != w.content {
+			t.Errorf("got %s part %q, want %q", w.contentType, got, w.content)
+		}
+	}
+	if _, err := r.NextPart(); err != io.EOF {
+		t.Errorf("got more parts than the two alternatives: %v", err)
+	}
+}
+
+func TestBuildEmailDefaultSubject(t *testing.T) {
+	message, err := buildEmail(EmailRequest{From: "oncall@example.com", To: []string{"team@example.com"}, MarkdownContent: "# Incidents\n"})
+	if err != nil {
+		t.Fatalf("buildEmail: %v", err)
+	}
+	m, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject := m.Header.Get("Subject"); !strings.HasPrefix(subject, "On-Call Report ") {
+		t.Errorf("got Subject %q, want the default one", subject)
+	}
+}
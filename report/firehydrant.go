@@ -0,0 +1,180 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultFireHydrantApiURL is the FireHydrant API used when none is configured
+	defaultFireHydrantApiURL = "https://api.firehydrant.io"
+	// fireHydrantPageSize is the number of incidents requested per page
+	fireHydrantPageSize = 100
+)
+
+// FireHydrantIncidentSource fetches incidents from FireHydrant
+type FireHydrantIncidentSource struct {
+	// FireHydrant API key
+	ApiKey string
+	// Name of teams assigned to the incidents. All incidents are kept if empty
+	Teams []string
+	// Custom field holding the root cause of an incident, defaults to "Root Cause"
+	RootCauseField string
+	// Base URL of the FireHydrant API, defaults to https://api.firehydrant.io
+	ApiURL string
+}
+
+type fireHydrantMilestone struct {
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+type fireHydrantIncident struct {
+	ID                    string                 `json:"id"`
+	Number                int                    `json:"number"`
+	Name                  string                 `json:"name"`
+	Summary               string                 `json:"summary"`
+	CustomerImpactSummary string                 `json:"customer_impact_summary"`
+	Severity              string                 `json:"severity"`
+	IncidentURL           string                 `json:"incident_url"`
+	CreatedAt             time.Time              `json:"created_at"`
+	Milestones            []fireHydrantMilestone `json:"milestones"`
+	RoleAssignments       []struct {
+		Status       string `json:"status"`
+		IncidentRole struct {
+			Name string `json:"name"`
+		} `json:"incident_role"`
+		User *struct {
+			Name  string `json:"name"`
+			Email string `json:"email"`
+		} `json:"user"`
+	} `json:"role_assignments"`
+	TeamAssignments []struct {
+		Team struct {
+			Name string `json:"name"`
+		} `json:"team"`
+	} `json:"team_assignments"`
+	CustomFields []struct {
+		Name        string `json:"name"`
+		ValueString string `json:"value_string"`
+	} `json:"custom_fields"`
+}
+
+// FetchIncidents implements IncidentSource
+func (s *FireHydrantIncidentSource) FetchIncidents(ctx context.Context, since, until time.Time) ([]*Incident, error) {
+	apiURL := defaultFireHydrantApiURL
+	if s.ApiURL != "" {
+		apiURL = strings.TrimSuffix(s.ApiURL, "/")
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+s.ApiKey)
+
+	params := url.Values{}
+	params.Set("per_page", strconv.Itoa(fireHydrantPageSize))
+	params.Set("start_date", since.UTC().Format(time.RFC3339))
+	params.Set("end_date", until.UTC().Format(time.RFC3339))
+
+	var incidents []*Incident
+	for page := 1; ; page++ {
+		params.Set("page", strconv.Itoa(page))
+
+		var response struct {
+			Data       []fireHydrantIncident `json:"data"`
+			Pagination struct {
+				Next *int `json:"next"`
+			} `json:"pagination"`
+		}
+		if err := getJSON(ctx, nil, apiURL+"/v1/incidents?"+params.Encode(), header, &response); err != nil {
+			return nil, fmt.Errorf("Error when listing FireHydrant incidents: %w", err)
+		}
+
+		for _, i := range response.Data {
+			if i.CreatedAt.Before(since) || !i.CreatedAt.Before(until) {
+				continue
+			}
+			if len(s.Teams) > 0 && !containsAnyFold(i.teams(), s.Teams) {
+				continue
+			}
+			incidents = append(incidents, s.toIncident(i))
+		}
+
+		if response.Pagination.Next == nil || len(response.Data) == 0 {
+			break
+		}
+	}
+
+	sort.Slice(incidents, func(i, j int) bool {
+		return incidents[i].CreatedAt.Before(incidents[j].CreatedAt)
+	})
+	return incidents, nil
+}
+
+// toIncident maps a FireHydrant incident onto the report's incident
+func (s *FireHydrantIncidentSource) toIncident(i fireHydrantIncident) *Incident {
+	incident := &Incident{
+		ID:                  fmt.Sprintf("FH-%d", i.Number),
+		Title:               i.Name,
+		Link:                i.IncidentURL,
+		Severity:            i.Severity,
+		Summary:             i.Summary,
+		CustomerImpactScope: i.CustomerImpactSummary,
+		CreatedAt:           i.CreatedAt,
+		ResolvedAt:          i.milestone("resolved", "closed"),
+	}
+	if incident.Link == "" {
+		incident.Link = fmt.Sprintf("https://app.firehydrant.io/incidents/%s", i.ID)
+	}
+
+	// Customers are impacted from the start of the incident until it is mitigated
+	started := i.milestone("started", "detected")
+	mitigated := i.milestone("mitigated", "resolved")
+	if !started.IsZero() && mitigated.After(started) {
+		incident.CustomerImpactDuration = mitigated.Sub(started)
+	}
+
+	for _, r := range i.RoleAssignments {
+		role := strings.ToLower(r.IncidentRole.Name)
+		if r.Status == "active" && r.User != nil && (strings.Contains(role, "commander") || strings.Contains(role, "lead")) {
+			incident.Commander = r.User.Name
+			incident.CommanderEmail = r.User.Email
+		}
+	}
+
+	rootCauseField := s.RootCauseField
+	if rootCauseField == "" {
+		rootCauseField = "Root Cause"
+	}
+	for _, f := range i.CustomFields {
+		if strings.EqualFold(f.Name, rootCauseField) {
+			incident.RootCause = f.ValueString
+		}
+	}
+
+	return incident
+}
+
+// milestone returns when the first of the given milestone types occurred, or the zero time if none did
+func (i fireHydrantIncident) milestone(types ...string) time.Time {
+	for _, t := range types {
+		for _, m := range i.Milestones {
+			if m.Type == t {
+				return m.OccurredAt
+			}
+		}
+	}
+	return time.Time{}
+}
+
+func (i fireHydrantIncident) teams() []string {
+	teams := make([]string, 0, len(i.TeamAssignments))
+	for _, t := range i.TeamAssignments {
+		teams = append(teams, t.Team.Name)
+	}
+	return teams
+}
@@ -0,0 +1,94 @@
+package report
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFireHydrantIncidentSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/v1/incidents" || r.Header.Get("Authorization") != "Bearer key" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		if query.Get("per_page") != "100" || query.Get("start_date") != "2021-07-14T00:00:00Z" || query.Get("end_date") != "2021-07-27T00:00:00Z" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch query.Get("page") {
+		case "1":
+			w.Write([]byte(`{"data": [
+				{"id": "f1", "number": 12, "name": "Checkout is down", "summary": "Rolled back", "customer_impact_summary": "All checkouts failed",
+				 "severity": "SEV1", "incident_url": "https://app.firehydrant.io/incidents/f1", "created_at": "2021-07-20T10:00:00Z",
+				 "milestones": [
+					{"type": "started", "occurred_at": "2021-07-20T09:50:00Z"},
+					{"type": "detected", "occurred_at": "2021-07-20T09:55:00Z"},
+					{"type": "mitigated", "occurred_at": "2021-07-20T10:20:00Z"},
+					{"type": "resolved", "occurred_at": "2021-07-20T11:00:00Z"}
+				 ],
+				 "role_assignments": [
+					{"status": "inactive", "incident_role": {"name": "Incident Commander"}, "user": {"name": "Former", "email": "former@example.com"}},
+					{"status": "active", "incident_role": {"name": "Incident Commander"}, "user": {"name": "Ina Commander", "email": "ic@example.com"}},
+					{"status": "active", "incident_role": {"name": "Scribe"}, "user": {"name": "Scribe", "email": "scribe@example.com"}}
+				 ],
+				 "team_assignments": [{"team": {"name": "Payments"}}],
+				 "custom_fields": [{"name": "Root Cause", "value_string": "Bad deploy"}]},
+				{"id": "f2", "number": 13, "name": "Search is slow", "created_at": "2021-07-21T10:00:00Z", "team_assignments": [{"team": {"name": "Search"}}]}
+			], "pagination": {"next": 2}}`))
+		case "2":
+			w.Write([]byte(`{"data": [
+				{"id": "f3", "number": 14, "name": "Payments are slow", "severity": "SEV3", "created_at": "2021-07-15T08:00:00Z",
+				 "milestones": [{"type": "started", "occurred_at": "2021-07-15T08:00:00Z"}],
+				 "team_assignments": [{"team": {"name": "payments"}}]}
+			], "pagination": {"next": null}}`))
+		default:
+			t.Errorf("unexpected page %s", r.URL)
+		}
+	}))
+	defer server.Close()
+
+	source := &FireHydrantIncidentSource{
+		ApiKey: "key",
+		Teams:  []string{"payments"},
+		ApiURL: server.URL,
+	}
+	since := time.Date(2021, 7, 14, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2021, 7, 27, 0, 0, 0, 0, time.UTC)
+	incidents, err := source.FetchIncidents(context.Background(), since, until)
+	if err != nil {
+		t.Fatalf("FetchIncidents: %v", err)
+	}
+
+	// Other teams' incidents are dropped, the others are sorted by creation
+	if len(incidents) != 2 || incidents[0].ID != "FH-14" || incidents[1].ID != "FH-12" {
+		t.Fatalf("got incidents %+v, want FH-14 and FH-12", incidents)
+	}
+	want := &Incident{
+		ID:                     "FH-12",
+		Title:                  "Checkout is down",
+		Link:                   "https://app.firehydrant.io/incidents/f1",
+		Severity:               "SEV1",
+		Commander:              "Ina Commander",
+		CommanderEmail:         "ic@example.com",
+		RootCause:              "Bad deploy",
+		Summary:                "Rolled back",
+		CustomerImpactScope:    "All checkouts failed",
+		CustomerImpactDuration: 30 * time.Minute,
+		CreatedAt:              time.Date(2021, 7, 20, 10, 0, 0, 0, time.UTC),
+		ResolvedAt:             time.Date(2021, 7, 20, 11, 0, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(incidents[1], want) {
+		t.Errorf("got %+v, want %+v", incidents[1], want)
+	}
+
+	// Still open and not mitigated, linked to FireHydrant by its ID
+	open := incidents[0]
+	if !open.ResolvedAt.IsZero() || open.CustomerImpactDuration != 0 || open.Severity != "SEV3" || open.Link != "https://app.firehydrant.io/incidents/f3" {
+		t.Errorf("got open incident %+v", open)
+	}
+}
@@ -0,0 +1,179 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// followUpLabel is added to the issues created by incidentist, so that they can be found again when rerunning a report
+const followUpLabel = "incidentist"
+
+// errNotFiled is returned by trackers that only link existing issues when there is none to link, the follow-up is then left as is
+var errNotFiled = errors.New("issue not filed")
+
+var (
+	// trackedItemRegex matches action items already followed up by an issue, e.g. "- Fix the alert ([OPS-123](https://...))"
+	trackedItemRegex = regexp.MustCompile(`\(\[([^\]]+)\]\(([^)]+)\)\)$`)
+	// issueLinkRegex matches action items that are just a link to an issue, as rendered by the built-in template
+	issueLinkRegex = regexp.MustCompile(`^\[([^\]]+)\]\(([^)]+)\)$`)
+)
+
+// followUp is an issue to file for an incident or a recurring page
+type followUp struct {
+	// key groups the issues of an incident or a recurring page, e.g. the incident ID
+	key string
+	// id identifies the follow-up across runs, even when the incident it is for is renamed
+	id          string
+	title       string
+	description string
+}
+
+// issueTracker creates the issues following up on incidents
+type issueTracker interface {
+	// ensureIssue returns the issue filed for f by a previous run, creating it if there is none
+	ensureIssue(ctx context.Context, f followUp) (Issue, error)
+}
+
+// trackFollowUps files an issue for every action item listed in the follow-up of each incident, or a single issue per incident when there are none.
+// The issues are added to the incidents, and action items are annotated with the issue following up on them.
+func trackFollowUps(ctx context.Context, tracker issueTracker, r *Report) error {
+	for _, i := range r.Incidents {
+		lines := strings.Split(i.FollowUp, "\n")
+		items := actionItems(lines)
+
+		if len(items) == 0 {
+			issue, err := tracker.ensureIssue(ctx, followUp{
+				key:         i.ID,
+				id:          i.ID,
+				title:       fmt.Sprintf("[%s] Follow-up: %s", i.ID, i.Title),
+				description: issueDescription(i, ""),
+			})
+			if errors.Is(err, errNotFiled) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("error filing follow-up of %s: %w", i.ID, err)
+			}
+			i.Issues = appendIssue(i.Issues, issue)
+			continue
+		}
+
+		for _, n := range items {
+			line := lines[n]
+			item := strings.TrimPrefix(strings.TrimSpace(line), "- ")
+			if m := issueLinkRegex.FindStringSubmatch(item); m != nil {
+				i.Issues = appendIssue(i.Issues, Issue{Key: m[1], Link: m[2]})
+				continue
+			}
+			if m := trackedItemRegex.FindStringSubmatch(item); m != nil {
+				i.Issues = appendIssue(i.Issues, Issue{Key: m[1], Title: strings.TrimSpace(strings.TrimSuffix(item, m[0])), Link: m[2]})
+				continue
+			}
+
+			// Action items are identified by their text, once filed they are annotated with their issue and skipped
+			issue, err := tracker.ensureIssue(ctx, followUp{
+				key:         i.ID,
+				id:          i.ID + " " + item,
+				title:       fmt.Sprintf("[%s] %s", i.ID, item),
+				description: issueDescription(i, item),
+			})
+			if errors.Is(err, errNotFiled) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("error filing action item of %s: %w", i.ID, err)
+			}
+			i.Issues = appendIssue(i.Issues, issue)
+			lines[n] = fmt.Sprintf("%s (%s)", line, link(issue.Key, issue.Link))
+		}
+		i.FollowUp = strings.Join(lines, "\n")
+	}
+	return nil
+}
+
+// trackRecurringPages files an issue for every page title that fired more than once without an incident, and adds it to those pages
+func trackRecurringPages(ctx context.Context, tracker issueTracker, r *Report) error {
+	var titles []string
+	pagesByTitle := make(map[string][]*Page)
+	for _, p := range r.OtherPages {
+		if _, ok := pagesByTitle[p.Title]; !ok {
+			titles = append(titles, p.Title)
+		}
+		pagesByTitle[p.Title] = append(pagesByTitle[p.Title], p)
+	}
+
+	for _, title := range titles {
+		pages := pagesByTitle[title]
+		if len(pages) < 2 {
+			continue
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "%q paged %d times without an incident:\n", title, len(pages))
+		for _, p := range pages {
+			fmt.Fprintf(&b, "- %s %s\n", p.CreatedAt.Format("2006-01-02 15:04:05"), p.Link)
+		}
+
+		issue, err := tracker.ensureIssue(ctx, followUp{
+			key:         title,
+			id:          "page " + title,
+			title:       "Recurring page: " + title,
+			description: b.String(),
+		})
+		if errors.Is(err, errNotFiled) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error filing follow-up of %q: %w", title, err)
+		}
+		for _, p := range pages {
+			p.Issues = appendIssue(p.Issues, issue)
+		}
+	}
+	return nil
+}
+
+// actionItems returns the indexes of the lines listed under the "Action items" question of a follow-up section
+func actionItems(lines []string) []int {
+	var items []int
+	inActionItems := false
+	for n, line := range lines {
+		line = strings.TrimSpace(line)
+		if followUpQuestionRegex.MatchString(line) {
+			inActionItems = line == "- **Action items**"
+			continue
+		}
+		if inActionItems && line != "" && line != strings.TrimSpace(filloutPlaceholder) {
+			items = append(items, n)
+		}
+	}
+	return items
+}
+
+// issueDescription describes the incident an issue follows up on, with the pages that fired during it
+func issueDescription(i *Incident, item string) string {
+	var b strings.Builder
+	if item != "" {
+		fmt.Fprintf(&b, "%s\n\n", item)
+	}
+	fmt.Fprintf(&b, "Follow-up of incident %s (%s): %s\n%s\n", i.ID, i.Severity, i.Title, i.Link)
+	if len(i.Pages) > 0 {
+		b.WriteString("\nPages:\n")
+		for _, p := range i.Pages {
+			fmt.Fprintf(&b, "- %s %s %s\n", p.CreatedAt.Format("2006-01-02 15:04:05"), p.Title, p.Link)
+		}
+	}
+	return b.String()
+}
+
+func appendIssue(issues []Issue, issue Issue) []Issue {
+	for _, existing := range issues {
+		if existing.Key == issue.Key {
+			return issues
+		}
+	}
+	return append(issues, issue)
+}
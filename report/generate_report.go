@@ -2,146 +2,175 @@
 package report
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
 	"time"
 )
 
-const (
-	filloutPlaceholder = "  _TODO: please fill out_"
-)
-
 type GenerateRequest struct {
 	// Name of Datadog teams
-	Teams      []string
+	Teams []string
 	// Name of PagerDuty teams
-	PdTeams    []string
+	PdTeams []string
 	// Start date of the report, in the format "YYYY-MM-DD" i.e. time.DateOnly
-	Since      string
+	Since string
 	// End date of the report, in the format "YYYY-MM-DD" i.e. time.DateOnly
-	Until      string
+	Until string
 	// Tag filters to use when fetching PagerDuty pages
 	TagFilters []string
 	// PagerDuty API token to use when fetching pages
-	AuthToken  string
+	AuthToken string
 	// PagerDuty page urgency
-	Urgency    string
+	Urgency string
 	// Replacement regex to apply to PagerDuty page titles
-	Replace    []string
+	Replace []string
 	// Datadog API key to use when fetching incidents
-	DdApiKey   string
+	DdApiKey string
 	// Datadog application key to use when fetching incidents
-	DdAppKey   string
+	DdAppKey string
+	// Datadog site to fetch incidents from, e.g. "datadoghq.eu", defaults to "datadoghq.com"
+	DdSite string
+	// Number of Datadog incidents to request per search page, defaults to 50
+	DdPageSize int
+	// Maximum number of Datadog incidents to fetch, defaults to 1000
+	DdMaxIncidents int
+	// Number of PagerDuty incidents to enrich concurrently, defaults to 4
+	Concurrency int
+	// Optional path of a file caching PagerDuty users across runs
+	UserCachePath string
+	// How long the PagerDuty user cache stays valid, defaults to 24 hours
+	UserCacheTTL time.Duration
+	// Source of incidents, defaults to a DatadogIncidentSource configured from the Dd* fields
+	IncidentSource IncidentSource
+	// Source of pages, defaults to a PagerDutyPageSource configured from the PagerDuty fields
+	PageSource PageSource
+	// Policy associating pages with incidents, defaults to pages fired from 15 minutes before an incident until it was resolved,
+	// sharing a service or a tag with it when reporting on several teams
+	Correlation *CorrelationPolicy
 }
 
 // Generate generates an incident report for the specified team and time range.
 // It fetches incidents from Datadog, pages from PagerDuty, and then associates pages with incidents and generates a markdown report.
+// If some incidents or pages could only be partially fetched, the report is returned along with a *PartialDataError listing them.
 func Generate(request GenerateRequest) (string, error) {
-	sinceAt, untilAt, err := parseDates(request.Since, request.Until)
-	if err != nil {
+	return GenerateContext(context.Background(), request)
+}
+
+// GenerateContext is like Generate, but stops fetching data and returns the context's error as soon as ctx is done.
+func GenerateContext(ctx context.Context, request GenerateRequest) (string, error) {
+	report, err := BuildReport(ctx, request)
+	if report == nil {
 		return "", err
 	}
+	markdown, renderErr := report.Markdown()
+	if renderErr != nil {
+		return "", renderErr
+	}
+	return markdown, err
+}
 
-	incidents, err := fetchIncidents(request.Teams, request.DdApiKey, request.DdAppKey, sinceAt, untilAt)
+// BuildReport fetches incidents and pages for the specified team and time range, and associates pages with incidents.
+// If some incidents or pages could only be partially fetched, the report is returned along with a *PartialDataError listing them.
+func BuildReport(ctx context.Context, request GenerateRequest) (*Report, error) {
+	sinceAt, untilAt, err := parseDates(request.Since, request.Until)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	pagerdutyTeams := request.Teams
-	if len(request.PdTeams) > 0 {
-		pagerdutyTeams = request.PdTeams
-
-		for i, team := range pagerdutyTeams {
-			pagerdutyTeams[i] = strings.ToLower(team)
-		}
-	}
-	pages, err := fetchPages(pagerdutyTeams, request.Since, request.Until, request.TagFilters, request.AuthToken, request.Urgency, request.Replace)
-	if err != nil {
-		return "", err
+	policy := defaultCorrelationPolicy
+	// Incidents of several teams often overlap, only pages from their services tell them apart
+	policy.MatchServices = len(request.Teams) > 1
+	if request.Correlation != nil {
+		policy = *request.Correlation
 	}
 
-	for _, p := range pages {
-		for _, i := range incidents {
-			if p.createdAt.After(i.createdAt.Add(-15*time.Minute)) &&
-				p.createdAt.Before(i.resolvedAt) {
-				i.pages = append(i.pages, p)
-				p.incidentIDs = append(p.incidentIDs, i.id)
-			}
+	incidentSource := request.IncidentSource
+	if incidentSource == nil {
+		incidentSource = &DatadogIncidentSource{
+			Teams:        request.Teams,
+			ApiKey:       request.DdApiKey,
+			AppKey:       request.DdAppKey,
+			Site:         request.DdSite,
+			PageSize:     request.DdPageSize,
+			MaxIncidents: request.DdMaxIncidents,
+			TagFields:    policy.Mapping.incidentFields(),
 		}
 	}
 
-	var md markdown
-
-	report := strings.Builder{}
+	var issues []DataIssue
+	incidents, err := incidentSource.FetchIncidents(ctx, sinceAt, untilAt)
+	var partialErr *PartialDataError
+	if err != nil && !errors.As(err, &partialErr) {
+		return nil, err
+	}
+	if partialErr != nil {
+		issues = append(issues, partialErr.Issues...)
+		partialErr = nil
+	}
 
-	title := strings.Title(fmt.Sprintf("%s On-Call Report %s", strings.Join(request.Teams, ", "), request.Until))
-	report.WriteString("---\n")
-	report.WriteString(fmt.Sprintf("title: %s\n", title))
-	report.WriteString("---\n")
+	pageSource := request.PageSource
+	if pageSource == nil {
+		// Listing the alerts of every page is slow, only do it when the incidents can be correlated with them
+		source := newPagerDutyPageSource(request)
+		source.FetchAlerts = policy.needsAlerts(incidents)
+		pageSource = source
+	}
 
-	md.para(fmt.Sprintf("Report for %s - %s: total incidents - %d, total pages - %d", request.Since, request.Until, len(incidents), len(pages)))
+	pages, err := pageSource.FetchPages(ctx, sinceAt, untilAt)
+	if err != nil && !errors.As(err, &partialErr) {
+		return nil, err
+	}
+	if partialErr != nil {
+		issues = append(issues, partialErr.Issues...)
+	}
 
-	timeFormat := "2006-01-02 @15:04:05"
-	for _, i := range incidents {
+	correlate(incidents, pages, policy, untilAt)
 
-		when := i.createdAt.Local().Format(timeFormat)
-		md.heading(3, link(fmt.Sprintf("%s | %s | %s | %s", i.sev, i.id, i.title, when), i.link))
-		md.heading(4, fmt.Sprintf("IC: %s", i.commanderEmail))
-		md.heading(4, "Root cause")
-		md.para("  " + i.rootCause)
-		md.heading(4, "Summary")
-		md.para("  " + i.summary)
-		if len(i.customerImpactScope) != 0 {
-			md.heading(4, fmt.Sprintf("Customer impact (%s)", i.customerImpactDuration.String()))
-			md.para("  " + i.customerImpactScope)
-		}
-		md.heading(4, "PagerDuty pages")
-		for _, p := range i.pages {
-			md.unordered(1, link(p.createdAt.Local().Format(timeFormat)+" "+p.title, p.link))
+	report := &Report{
+		Title:          strings.Title(fmt.Sprintf("%s On-Call Report %s", strings.Join(request.Teams, ", "), request.Until)),
+		Teams:          request.Teams,
+		Since:          request.Since,
+		Until:          request.Until,
+		Incidents:      incidents,
+		TotalIncidents: len(incidents),
+		TotalPages:     len(pages),
+	}
+	for _, p := range pages {
+		if len(p.IncidentIDs) == 0 {
+			report.OtherPages = append(report.OtherPages, p)
 		}
-		md.br()
+	}
 
-		md.heading(4, "Action taken")
-		md.para(filloutPlaceholder)
-		md.heading(4, "Follow-up")
-		md.unordered(1, "**Happened before/common theme**")
-		md.para(filloutPlaceholder)
-		md.unordered(1, "**How can we prevent it**")
-		md.para(filloutPlaceholder)
-		md.unordered(1, "**Runbooks**")
-		md.para(filloutPlaceholder)
-		md.unordered(1, "**Related PRs**")
-		md.para(filloutPlaceholder)
-		md.unordered(1, "**Action items**")
-		md.para(filloutPlaceholder)
+	if len(issues) > 0 {
+		report.DataIssues = issues
+		return report, &PartialDataError{Issues: issues}
 	}
+	return report, nil
+}
 
-	md.heading(3, "Other Pages")
+// newPagerDutyPageSource creates the default page source from the PagerDuty fields of the request
+func newPagerDutyPageSource(request GenerateRequest) *PagerDutyPageSource {
+	pagerdutyTeams := request.Teams
+	if len(request.PdTeams) > 0 {
+		pagerdutyTeams = request.PdTeams
 
-	for _, p := range pages {
-		if len(p.incidentIDs) != 0 {
-			continue
-		}
-		md.unordered(1, link(p.createdAt.Local().Format(timeFormat)+" "+p.title, p.link))
-		md.unordered(2, fmt.Sprintf("**Ack'ed by**: %s", strings.Join(p.responders, ", ")))
-		if len(p.notes) != 0 {
-			md.unordered(2, "**Notes**:")
-			for _, n := range p.notes {
-				if n.userEmail != "" {
-					md.unordered(3, fmt.Sprintf("**%s**: %s", n.userEmail, n.content))
-				} else {
-					md.unordered(3, n.content)
-				}
-			}
-			md.br()
+		for i, team := range pagerdutyTeams {
+			pagerdutyTeams[i] = strings.ToLower(team)
 		}
-		md.unordered(2, "**Action taken**: "+filloutPlaceholder)
-		md.unordered(2, "**Follow-up**: "+filloutPlaceholder)
 	}
 
-	report.WriteString(md.String())
-	return report.String(), nil
+	return &PagerDutyPageSource{
+		Teams:         pagerdutyTeams,
+		TagFilters:    request.TagFilters,
+		AuthToken:     request.AuthToken,
+		Urgency:       request.Urgency,
+		Replace:       request.Replace,
+		Concurrency:   request.Concurrency,
+		UserCachePath: request.UserCachePath,
+		UserCacheTTL:  request.UserCacheTTL,
+	}
 }
 
 func parseDates(since, until string) (sinceAt, untilAt time.Time, err error) {
@@ -0,0 +1,347 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// assertGolden compares got with the content of testdata/name, or rewrites it when running with -update
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s differs from the golden file, rerun with -update if the change is expected.\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+type fakeIncidentSource struct {
+	incidents []*Incident
+	err       error
+}
+
+func (s *fakeIncidentSource) FetchIncidents(ctx context.Context, since, until time.Time) ([]*Incident, error) {
+	return s.incidents, s.err
+}
+
+type fakePageSource struct {
+	pages []*Page
+	err   error
+}
+
+func (s *fakePageSource) FetchPages(ctx context.Context, since, until time.Time) ([]*Page, error) {
+	return s.pages, s.err
+}
+
+// reportStart is the start of the day incidents and pages of the test reports happen on
+var reportStart = time.Date(2021, 7, 20, 10, 0, 0, 0, time.UTC)
+
+func at(minutes int) time.Time {
+	return reportStart.Add(time.Duration(minutes) * time.Minute)
+}
+
+func TestBuildReport(t *testing.T) {
+	tests := []struct {
+		name      string
+		incidents func() []*Incident
+		pages     func() []*Page
+		// Page titles by incident ID, then pages without an incident
+		wantPages      map[string][]string
+		wantOtherPages []string
+	}{
+		{
+			name: "pages within the window of the incident",
+			incidents: func() []*Incident {
+				return []*Incident{{ID: "#incident-1", CreatedAt: at(0), ResolvedAt: at(60)}}
+			},
+			pages: func() []*Page {
+				return []*Page{
+					{Title: "long before", CreatedAt: at(-16)},
+					{Title: "lead", CreatedAt: at(-14)},
+					{Title: "during", CreatedAt: at(30)},
+					{Title: "after", CreatedAt: at(61)},
+				}
+			},
+			wantPages:      map[string][]string{"#incident-1": {"lead", "during"}},
+			wantOtherPages: []string{"long before", "after"},
+		},
+		{
+			name: "pages of overlapping incidents",
+			incidents: func() []*Incident {
+				return []*Incident{
+					{ID: "#incident-1", CreatedAt: at(0), ResolvedAt: at(60)},
+					{ID: "#incident-2", CreatedAt: at(30), ResolvedAt: at(90)},
+				}
+			},
+			pages: func() []*Page {
+				return []*Page{
+					{Title: "first", CreatedAt: at(5)},
+					{Title: "both", CreatedAt: at(45)},
+					{Title: "second", CreatedAt: at(75)},
+				}
+			},
+			wantPages: map[string][]string{
+				"#incident-1": {"first", "both"},
+				"#incident-2": {"both", "second"},
+			},
+		},
+		{
+			name: "open incidents get pages until the end of the report",
+			incidents: func() []*Incident {
+				return []*Incident{{ID: "#incident-1", CreatedAt: at(0)}}
+			},
+			pages: func() []*Page {
+				return []*Page{{Title: "days later", CreatedAt: at(3 * 24 * 60)}}
+			},
+			wantPages: map[string][]string{"#incident-1": {"days later"}},
+		},
+		{
+			name:      "no incidents",
+			incidents: func() []*Incident { return nil },
+			pages: func() []*Page {
+				return []*Page{{Title: "alone", CreatedAt: at(0)}}
+			},
+			wantPages:      map[string][]string{},
+			wantOtherPages: []string{"alone"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			incidents, pages := tt.incidents(), tt.pages()
+			r, err := BuildReport(context.Background(), GenerateRequest{
+				Teams:          []string{"my-team"},
+				Since:          "2021-07-14",
+				Until:          "2021-07-27",
+				IncidentSource: &fakeIncidentSource{incidents: incidents},
+				PageSource:     &fakePageSource{pages: pages},
+			})
+			if err != nil {
+				t.Fatalf("BuildReport: %v", err)
+			}
+
+			gotPages := map[string][]string{}
+			for _, i := range r.Incidents {
+				for _, p := range i.Pages {
+					gotPages[i.ID] = append(gotPages[i.ID], p.Title)
+				}
+			}
+			if !reflect.DeepEqual(gotPages, tt.wantPages) {
+				t.Errorf("got pages %v, want %v", gotPages, tt.wantPages)
+			}
+
+			var gotOtherPages []string
+			for _, p := range r.OtherPages {
+				gotOtherPages = append(gotOtherPages, p.Title)
+			}
+			if !reflect.DeepEqual(gotOtherPages, tt.wantOtherPages) {
+				t.Errorf("got other pages %v, want %v", gotOtherPages, tt.wantOtherPages)
+			}
+
+			if r.TotalIncidents != len(incidents) || r.TotalPages != len(pages) {
+				t.Errorf("got %d incidents and %d pages in total, want %d and %d", r.TotalIncidents, r.TotalPages, len(incidents), len(pages))
+			}
+		})
+	}
+}
+
+func TestBuildReportMatchServices(t *testing.T) {
+	pages := func() []*Page {
+		return []*Page{
+			{Title: "Checkout errors", Service: "checkout", CreatedAt: at(5)},
+			{Title: "Search latency", Service: "search", CreatedAt: at(10)},
+		}
+	}
+	tests := []struct {
+		name        string
+		teams       []string
+		correlation *CorrelationPolicy
+		want        []string
+	}{
+		{"single team", []string{"checkout"}, nil, []string{"Checkout errors", "Search latency"}},
+		{"several teams", []string{"checkout", "search"}, nil, []string{"Checkout errors"}},
+		{"several teams without matching", []string{"checkout", "search"}, &defaultCorrelationPolicy, []string{"Checkout errors", "Search latency"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := BuildReport(context.Background(), GenerateRequest{
+				Teams:          tt.teams,
+				Since:          "2021-07-14",
+				Until:          "2021-07-27",
+				IncidentSource: &fakeIncidentSource{incidents: []*Incident{{ID: "#incident-1", Services: []string{"checkout"}, CreatedAt: at(0), ResolvedAt: at(60)}}},
+				PageSource:     &fakePageSource{pages: pages()},
+				Correlation:    tt.correlation,
+			})
+			if err != nil {
+				t.Fatalf("BuildReport: %v", err)
+			}
+			var got []string
+			for _, p := range r.Incidents[0].Pages {
+				got = append(got, p.Title)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got pages %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildReportDataQuality(t *testing.T) {
+	incidentIssue := DataIssue{Subject: "#incident-2", Link: "https://dd/2", Problem: "could not find incident commander user-2"}
+	pageIssue := DataIssue{Subject: "Disk full", Link: "https://pd/1", Problem: "could not fetch notes: boom"}
+	fatal := errors.New("unauthorized")
+
+	tests := []struct {
+		name         string
+		incidentsErr error
+		pagesErr     error
+		wantReport   bool
+		wantIssues   []DataIssue
+		wantErr      error
+	}{
+		{
+			name:       "complete data",
+			wantReport: true,
+		},
+		{
+			name:         "partial incidents and pages",
+			incidentsErr: &PartialDataError{Issues: []DataIssue{incidentIssue}},
+			pagesErr:     &PartialDataError{Issues: []DataIssue{pageIssue}},
+			wantReport:   true,
+			wantIssues:   []DataIssue{incidentIssue, pageIssue},
+		},
+		{
+			name:       "partial pages",
+			pagesErr:   &PartialDataError{Issues: []DataIssue{pageIssue}},
+			wantReport: true,
+			wantIssues: []DataIssue{pageIssue},
+		},
+		{
+			name:         "incidents could not be fetched",
+			incidentsErr: fatal,
+			wantErr:      fatal,
+		},
+		{
+			name:     "pages could not be fetched",
+			pagesErr: fatal,
+			wantErr:  fatal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := BuildReport(context.Background(), GenerateRequest{
+				Teams:          []string{"my-team"},
+				Since:          "2021-07-14",
+				Until:          "2021-07-27",
+				IncidentSource: &fakeIncidentSource{incidents: []*Incident{{ID: "#incident-1", CreatedAt: at(0)}}, err: tt.incidentsErr},
+				PageSource:     &fakePageSource{pages: []*Page{{Title: "Disk full", Link: "https://pd/1", CreatedAt: at(5)}}, err: tt.pagesErr},
+			})
+
+			if (r != nil) != tt.wantReport {
+				t.Fatalf("got report %v, want one: %v", r, tt.wantReport)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("got error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+
+			if len(tt.wantIssues) == 0 {
+				if err != nil {
+					t.Errorf("got error %v, want none", err)
+				}
+				return
+			}
+			var partialErr *PartialDataError
+			if !errors.As(err, &partialErr) {
+				t.Fatalf("got error %v, want a *PartialDataError", err)
+			}
+			if !reflect.DeepEqual(partialErr.Issues, tt.wantIssues) {
+				t.Errorf("got issues %v, want %v", partialErr.Issues, tt.wantIssues)
+			}
+			if !reflect.DeepEqual(r.DataIssues, tt.wantIssues) {
+				t.Errorf("got report issues %v, want %v", r.DataIssues, tt.wantIssues)
+			}
+		})
+	}
+}
+
+func TestBuildReportInvalidDates(t *testing.T) {
+	tests := []struct {
+		since, until string
+	}{
+		{"2021-07-14", "yesterday"},
+		{"14/07/2021", "2021-07-27"},
+		{"2021-07-27", "2021-07-14"},
+	}
+	for _, tt := range tests {
+		r, err := BuildReport(context.Background(), GenerateRequest{
+			Since:          tt.since,
+			Until:          tt.until,
+			IncidentSource: &fakeIncidentSource{},
+			PageSource:     &fakePageSource{},
+		})
+		if err == nil || r != nil {
+			t.Errorf("BuildReport(%s, %s) = %v, %v, want an error", tt.since, tt.until, r, err)
+		}
+	}
+}
+
+func TestBuildReportJSON(t *testing.T) {
+	r, err := BuildReport(context.Background(), GenerateRequest{
+		Teams: []string{"my-team"},
+		Since: "2021-07-14",
+		Until: "2021-07-27",
+		IncidentSource: &fakeIncidentSource{incidents: []*Incident{
+			{
+				ID:                     "#incident-1",
+				Title:                  "Checkout is down",
+				Link:                   "https://app.datadoghq.com/incidents/1",
+				Severity:               "SEV-2",
+				Commander:              "Ina Commander",
+				CommanderEmail:         "ic@example.com",
+				RootCause:              "Bad deploy",
+				Summary:                "Rolled back",
+				CustomerImpactScope:    "All checkouts failed",
+				CustomerImpactDuration: 90 * time.Second,
+				CreatedAt:              at(0),
+				ResolvedAt:             at(60),
+			},
+			{ID: "#incident-2", Title: "Search is slow", Link: "https://app.datadoghq.com/incidents/2", Severity: "SEV-3", CreatedAt: at(24 * 60)},
+		}},
+		PageSource: &fakePageSource{
+			pages: []*Page{
+				{Title: "Checkout errors", Link: "https://acme.pagerduty.com/incidents/Q1", CreatedAt: at(-5), Urgency: "high", Responders: []string{"oncall@example.com"}},
+				{Title: "Disk full", Link: "https://acme.pagerduty.com/incidents/Q2", CreatedAt: at(120), Urgency: "low",
+					Notes: []PageNote{{Content: "Cleaned up", UserName: "On Call", UserEmail: "oncall@example.com"}}},
+			},
+			err: &PartialDataError{Issues: []DataIssue{{Subject: "Disk full", Link: "https://acme.pagerduty.com/incidents/Q2", Problem: "could not fetch responders: boom"}}},
+		},
+	})
+	var partialErr *PartialDataError
+	if !errors.As(err, &partialErr) {
+		t.Fatalf("got error %v, want a *PartialDataError", err)
+	}
+
+	data, err := r.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "report.json", append(data, '\n'))
+}
@@ -0,0 +1,205 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultGitHubApiURL is the GitHub API used when none is configured
+	defaultGitHubApiURL = "https://api.github.com"
+	// gitHubPageSize is the number of issues requested per page
+	gitHubPageSize = 100
+)
+
+// GitHubRequest describes where to file the follow-ups of a report in GitHub issues
+type GitHubRequest struct {
+	// Repository to file issues in, in the form "owner/repo"
+	Repo string
+	// Label added to the issues filed by incidentist, defaults to "incidentist"
+	Label string
+	// GitHub token allowed to create issues in Repo
+	Token string
+	// Base URL of the GitHub API, defaults to https://api.github.com. GitHub Enterprise uses https://<host>/api/v3
+	ApiURL string
+	// Report whose incidents and recurring pages to follow up on. Issues are added to its incidents and pages
+	Report *Report
+}
+
+type gitHubIssue struct {
+	Number      int       `json:"number"`
+	Title       string    `json:"title"`
+	Body        string    `json:"body"`
+	HTMLURL     string    `json:"html_url"`
+	State       string    `json:"state"`
+	PullRequest *struct{} `json:"pull_request"`
+}
+
+type gitHubTracker struct {
+	request GitHubRequest
+	// Issues carrying the label, listed once on first use
+	issues []gitHubIssue
+	listed bool
+}
+
+// CreateGitHubIssues files a GitHub issue for every action item of the incidents of the report, or for every incident without action items,
+// and for every page title that fired more than once without an incident.
+// Issues filed by a previous run are found again by the marker hidden in their body rather than duplicated, even if the incident was renamed.
+// Only the section of their body incidentist generated is kept up to date, and only while they are open.
+func CreateGitHubIssues(ctx context.Context, request GitHubRequest) error {
+	tracker := &gitHubTracker{request: request}
+	if err := trackFollowUps(ctx, tracker, request.Report); err != nil {
+		return err
+	}
+	return trackRecurringPages(ctx, tracker, request.Report)
+}
+
+func (t *gitHubTracker) ensureIssue(ctx context.Context, f followUp) (Issue, error) {
+	if err := t.listIssues(ctx); err != nil {
+		return Issue{}, err
+	}
+
+	existing := t.findIssue(f)
+	if existing == nil {
+		var created gitHubIssue
+		issue := map[string]interface{}{
+			"title":  f.title,
+			"body":   gitHubSection(f.description) + "\n\n" + gitHubMarker(f.id),
+			"labels": []string{t.label()},
+		}
+		if err := postJSON(ctx, t.repoURL()+"/issues", t.header(), issue, &created); err != nil {
+			return Issue{}, fmt.Errorf("error creating issue: %w", err)
+		}
+		t.issues = append(t.issues, created)
+		return t.issue(created), nil
+	}
+
+	// Closed issues are left as they were closed, and the title of open ones and whatever was written around the generated section are the team's
+	if existing.State != "open" {
+		return t.issue(*existing), nil
+	}
+	if body, changed := updateGitHubSection(existing.Body, f.description); changed {
+		update := map[string]string{"body": body}
+		if err := sendJSON(ctx, http.MethodPatch, fmt.Sprintf("%s/issues/%d", t.repoURL(), existing.Number), t.header(), update, nil); err != nil {
+			return Issue{}, fmt.Errorf("error updating issue #%d: %w", existing.Number, err)
+		}
+		existing.Body = body
+	}
+	return t.issue(*existing), nil
+}
+
+// findIssue finds the issue filed for f by a previous run, by its marker or, for issues filed before markers were added, by its title
+func (t *gitHubTracker) findIssue(f followUp) *gitHubIssue {
+	marker := gitHubMarker(f.id)
+	for n := range t.issues {
+		if strings.Contains(t.issues[n].Body, marker) {
+			return &t.issues[n]
+		}
+	}
+	for n := range t.issues {
+		if t.issues[n].Title == f.title && !strings.Contains(t.issues[n].Body, gitHubMarkerPrefix) {
+			return &t.issues[n]
+		}
+	}
+	return nil
+}
+
+// gitHubMarkerPrefix starts the HTML comments identifying the follow-up an issue was filed for
+const gitHubMarkerPrefix = "<!-- " + followUpLabel + ":"
+
+// gitHubMarker renders the HTML comment identifying a follow-up in the body of its issue, which GitHub doesn't display.
+// The id is escaped so that it can't end the comment.
+func gitHubMarker(id string) string {
+	return gitHubMarkerPrefix + url.QueryEscape(id) + " -->"
+}
+
+const (
+	// gitHubSectionStart and gitHubSectionEnd surround the section of an issue body generated by incidentist, which GitHub doesn't display
+	gitHubSectionStart = "<!-- " + followUpLabel + " start -->"
+	gitHubSectionEnd   = "<!-- " + followUpLabel + " end -->"
+)
+
+// gitHubSection renders the section of an issue body generated from a follow-up description
+func gitHubSection(description string) string {
+	return gitHubSectionStart + "\n" + description + gitHubSectionEnd
+}
+
+// updateGitHubSection rewrites the generated section of an issue body, and reports whether it changed.
+// Bodies without one, e.g. of issues filed by hand, are left as they are.
+func updateGitHubSection(body, description string) (string, bool) {
+	// Bodies edited on GitHub come back with CRLF line endings
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	start := strings.Index(body, gitHubSectionStart)
+	end := strings.Index(body, gitHubSectionEnd)
+	if start < 0 || end < start {
+		return body, false
+	}
+	updated := body[:start] + gitHubSection(description) + body[end+len(gitHubSectionEnd):]
+	return updated, updated != body
+}
+
+// listIssues lists the open and closed issues carrying the label, so that issues aren't filed again once closed
+func (t *gitHubTracker) listIssues(ctx context.Context) error {
+	if t.listed {
+		return nil
+	}
+
+	params := url.Values{}
+	params.Set("labels", t.label())
+	params.Set("state", "all")
+	params.Set("per_page", strconv.Itoa(gitHubPageSize))
+	for page := 1; ; page++ {
+		params.Set("page", strconv.Itoa(page))
+
+		var issues []gitHubIssue
+		if err := getJSON(ctx, nil, t.repoURL()+"/issues?"+params.Encode(), t.header(), &issues); err != nil {
+			return fmt.Errorf("error listing issues of %s: %w", t.request.Repo, err)
+		}
+		for _, issue := range issues {
+			// The issues API lists pull requests too
+			if issue.PullRequest == nil {
+				t.issues = append(t.issues, issue)
+			}
+		}
+		if len(issues) < gitHubPageSize {
+			break
+		}
+	}
+
+	t.listed = true
+	return nil
+}
+
+func (t *gitHubTracker) issue(issue gitHubIssue) Issue {
+	return Issue{
+		Key:   fmt.Sprintf("#%d", issue.Number),
+		Title: issue.Title,
+		Link:  issue.HTMLURL,
+	}
+}
+
+func (t *gitHubTracker) label() string {
+	if t.request.Label == "" {
+		return followUpLabel
+	}
+	return t.request.Label
+}
+
+func (t *gitHubTracker) repoURL() string {
+	apiURL := defaultGitHubApiURL
+	if t.request.ApiURL != "" {
+		apiURL = strings.TrimSuffix(t.request.ApiURL, "/")
+	}
+	return apiURL + "/repos/" + t.request.Repo
+}
+
+func (t *gitHubTracker) header() http.Header {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+t.request.Token)
+	header.Set("Accept", "application/vnd.github+json")
+	return header
+}
@@ -0,0 +1,188 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGitHub serves the issues of a single repository, and records the requests changing them
+type fakeGitHub struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	issues   []gitHubIssue
+	requests []string
+}
+
+func (g *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		g.t.Errorf("unexpected Authorization header %q", r.Header.Get("Authorization"))
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	const repo = "/repos/my-org/my-repo/issues"
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == repo:
+		if r.URL.Query().Get("labels") != "incidentist" || r.URL.Query().Get("state") != "all" {
+			g.t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		issues := g.issues
+		if r.URL.Query().Get("page") != "1" {
+			issues = nil
+		}
+		json.NewEncoder(w).Encode(issues)
+	case r.Method == http.MethodPost && r.URL.Path == repo:
+		var body struct {
+			Title  string   `json:"title"`
+			Body   string   `json:"body"`
+			Labels []string `json:"labels"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if !reflect.DeepEqual(body.Labels, []string{"incidentist"}) {
+			g.t.Errorf("got labels %v", body.Labels)
+		}
+		issue := gitHubIssue{Number: len(g.issues) + 1, Title: body.Title, Body: body.Body, State: "open"}
+		issue.HTMLURL = fmt.Sprintf("https://github.com/my-org/my-repo/issues/%d", issue.Number)
+		g.issues = append(g.issues, issue)
+		g.requests = append(g.requests, "POST "+body.Title)
+		json.NewEncoder(w).Encode(issue)
+	case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, repo+"/"):
+		number, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, repo+"/"))
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if _, ok := body["title"]; ok || len(body) != 1 {
+			g.t.Errorf("got update %v, want only the body", body)
+		}
+		for n := range g.issues {
+			if g.issues[n].Number == number {
+				g.issues[n].Body = body["body"]
+			}
+		}
+		g.requests = append(g.requests, fmt.Sprintf("PATCH #%d", number))
+		json.NewEncoder(w).Encode(struct{}{})
+	default:
+		g.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		http.NotFound(w, r)
+	}
+}
+
+// gitHubFollowUpReport has an incident without action items, one with two of them, and a page that fired twice without an incident
+func gitHubFollowUpReport() *Report {
+	return &Report{
+		Incidents: []*Incident{
+			{ID: "#incident-1", Title: "Checkout is down for everyone", Link: "https://app.datadoghq.com/incidents/1", Severity: "SEV-1"},
+			{ID: "#incident-2", Title: "Search is slow", Link: "https://app.datadoghq.com/incidents/2", Severity: "SEV-3",
+				FollowUp: "- **Action items**\n  - Add an index\n  - Tune the cache"},
+		},
+		OtherPages: []*Page{
+			{Title: "Disk full", Link: "https://acme.pagerduty.com/incidents/Q1", CreatedAt: at(0)},
+			{Title: "Certificate expiring", Link: "https://acme.pagerduty.com/incidents/Q2", CreatedAt: at(60)},
+			{Title: "Disk full", Link: "https://acme.pagerduty.com/incidents/Q3", CreatedAt: at(120)},
+		},
+	}
+}
+
+func TestCreateGitHubIssues(t *testing.T) {
+	github := &fakeGitHub{t: t, issues: []gitHubIssue{
+		// Filed for the incident before it was renamed, then added to by the team, with CRLF line endings as GitHub returns them
+		{Number: 1, Title: "[#incident-1] Follow-up: Checkout is down", State: "open", HTMLURL: "https://github.com/my-org/my-repo/issues/1",
+			Body: "Owner: @jane\r\n\r\n" + gitHubSection("Old description\r\n") + "\r\n\r\nNotes from the team\r\n\r\n" + gitHubMarker("#incident-1")},
+		// Filed before issues were marked, found by title and left as is
+		{Number: 2, Title: "[#incident-2] Add an index", Body: "Old description", State: "open", HTMLURL: "https://github.com/my-org/my-repo/issues/2"},
+		{Number: 3, Title: "[#incident-2] Tune the cache", State: "open", HTMLURL: "https://github.com/my-org/my-repo/pull/3", PullRequest: &struct{}{}},
+		// Closed by the team, and left as they closed it
+		{Number: 4, Title: "Disk full pages", State: "closed", HTMLURL: "https://github.com/my-org/my-repo/issues/4",
+			Body: gitHubSection("Old description\n") + "\n\n" + gitHubMarker("page Disk full")},
+	}}
+	server := httptest.NewServer(github)
+	defer server.Close()
+
+	request := GitHubRequest{Repo: "my-org/my-repo", Token: "token", ApiURL: server.URL + "/"}
+
+	r := gitHubFollowUpReport()
+	request.Report = r
+	if err := CreateGitHubIssues(context.Background(), request); err != nil {
+		t.Fatalf("CreateGitHubIssues: %v", err)
+	}
+
+	wantRequests := []string{
+		"PATCH #1",
+		"POST [#incident-2] Tune the cache",
+	}
+	if !reflect.DeepEqual(github.requests, wantRequests) {
+		t.Errorf("got requests %q, want %q", github.requests, wantRequests)
+	}
+
+	// Only the generated section is rewritten
+	wantBody := "Owner: @jane\n\n" + gitHubSection(issueDescription(r.Incidents[0], "")) + "\n\nNotes from the team\n\n" + gitHubMarker("#incident-1")
+	if github.issues[0].Body != wantBody || github.issues[0].Title != "[#incident-1] Follow-up: Checkout is down" {
+		t.Errorf("got issue %q:\n%s\nwant:\n%s", github.issues[0].Title, github.issues[0].Body, wantBody)
+	}
+	if want := []Issue{{Key: "#1", Title: "[#incident-1] Follow-up: Checkout is down", Link: "https://github.com/my-org/my-repo/issues/1"}}; !reflect.DeepEqual(r.Incidents[0].Issues, want) {
+		t.Errorf("got issues %v, want %v", r.Incidents[0].Issues, want)
+	}
+	wantFollowUp := "- **Action items**\n" +
+		"  - Add an index ([#2](https://github.com/my-org/my-repo/issues/2))\n" +
+		"  - Tune the cache ([#5](https://github.com/my-org/my-repo/issues/5))"
+	if r.Incidents[1].FollowUp != wantFollowUp {
+		t.Errorf("got follow-up:\n%s\nwant:\n%s", r.Incidents[1].FollowUp, wantFollowUp)
+	}
+	wantBody = gitHubSection(issueDescription(r.Incidents[1], "Tune the cache")) + "\n\n" + gitHubMarker("#incident-2 Tune the cache")
+	if body := github.issues[4].Body; body != wantBody {
+		t.Errorf("got body:\n%s\nwant:\n%s", body, wantBody)
+	}
+
+	recurring := Issue{Key: "#4", Title: "Disk full pages", Link: "https://github.com/my-org/my-repo/issues/4"}
+	for _, p := range []*Page{r.OtherPages[0], r.OtherPages[2]} {
+		if !reflect.DeepEqual(p.Issues, []Issue{recurring}) {
+			t.Errorf("got issues %v for %s, want %v", p.Issues, p.Link, recurring)
+		}
+	}
+	if len(r.OtherPages[1].Issues) != 0 {
+		t.Errorf("got issues %v for a page that fired once", r.OtherPages[1].Issues)
+	}
+	if want := gitHubSection("Old description\n") + "\n\n" + gitHubMarker("page Disk full"); github.issues[3].Body != want {
+		t.Errorf("got closed issue body %q, want it untouched", github.issues[3].Body)
+	}
+
+	// Running the same report again finds every issue and leaves them as they are
+	github.requests = nil
+	request.Report = gitHubFollowUpReport()
+	if err := CreateGitHubIssues(context.Background(), request); err != nil {
+		t.Fatalf("CreateGitHubIssues: %v", err)
+	}
+	if len(github.requests) != 0 {
+		t.Errorf("got requests %q when running again, want none", github.requests)
+	}
+}
+
+func TestUpdateGitHubSection(t *testing.T) {
+	body := "Intro\n" + gitHubSection("Old\n") + "\nOutro"
+	if got, changed := updateGitHubSection(body, "New\n"); !changed || got != "Intro\n"+gitHubSection("New\n")+"\nOutro" {
+		t.Errorf("got %q, %v", got, changed)
+	}
+	if got, changed := updateGitHubSection(body, "Old\n"); changed || got != body {
+		t.Errorf("got %q, %v, want the body unchanged", got, changed)
+	}
+	// Issues filed by hand have no generated section to update
+	if got, changed := updateGitHubSection("Written by hand", "New\n"); changed || got != "Written by hand" {
+		t.Errorf("got %q, %v, want the body unchanged", got, changed)
+	}
+}
+
+func TestGitHubMarker(t *testing.T) {
+	if got, want := gitHubMarker("page CPU --> 90%"), "<!-- incidentist:page+CPU+--%3E+90%25 -->"; got != want {
+		t.Errorf("gitHubMarker() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,278 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GrafanaOnCallPageSource fetches pages from Grafana OnCall alert groups
+type GrafanaOnCallPageSource struct {
+	// Base URL of the Grafana OnCall API, e.g. https://oncall-prod-us-central-0.grafana.net/oncall
+	URL string
+	// Grafana OnCall API token
+	Token string
+	// Name of Grafana OnCall teams
+	Teams []string
+	// Only keep alert groups with one of these labels, in the form "name:value"
+	TagFilters []string
+	// Replacement regexes to apply to alert group titles, in the form "/regex/replacement/"
+	Replace []string
+	// Number of alert groups to fetch details for concurrently, defaults to 4
+	Concurrency int
+}
+
+type oncallLabel struct {
+	Key struct {
+		Name string `json:"name"`
+	} `json:"key"`
+	Value struct {
+		Name string `json:"name"`
+	} `json:"value"`
+}
+
+type oncallAlertGroup struct {
+	ID             string        `json:"id"`
+	Title          string        `json:"title"`
+	CreatedAt      time.Time     `json:"created_at"`
+	AcknowledgedBy string        `json:"acknowledged_by"`
+	ResolvedBy     string        `json:"resolved_by"`
+	Labels         []oncallLabel `json:"labels"`
+	Permalinks     struct {
+		Web string `json:"web"`
+	} `json:"permalinks"`
+}
+
+type oncallResolutionNote struct {
+	Author string `json:"author"`
+	Text   string `json:"text"`
+}
+
+type oncallUser struct {
+	Email    string `json:"email"`
+	Username string `json:"username"`
+}
+
+// FetchPages implements PageSource
+func (s *GrafanaOnCallPageSource) FetchPages(ctx context.Context, since, until time.Time) ([]*Page, error) {
+	regexReplace, err := getRegexReplace(s.Replace)
+	if err != nil {
+		return nil, err
+	}
+
+	teamIDs, err := s.getTeamIds(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := s.listAlertGroups(ctx, teamIDs, since, until)
+	if err != nil {
+		return nil, err
+	}
+
+	users := &oncallUsers{source: s, users: make(map[string]oncallUser)}
+	var quality dataQuality
+
+	results := make([]*Page, len(groups))
+	err = forEachConcurrently(len(groups), s.Concurrency, func(i int) error {
+		g := groups[i]
+		if len(s.TagFilters) > 0 && !matchesTagFilters(oncallLabelsToTagSet(g.Labels), s.TagFilters) {
+			return nil
+		}
+		results[i] = s.fetchPage(ctx, users, &quality, g, regexReplace)
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []*Page
+	for _, p := range results {
+		if p != nil {
+			pages = append(pages, p)
+		}
+	}
+	return pages, quality.err()
+}
+
+// fetchPage enriches a single alert group with its resolution notes and the user who acknowledged it
+func (s *GrafanaOnCallPageSource) fetchPage(ctx context.Context, users *oncallUsers, quality *dataQuality, g oncallAlertGroup, regexReplace map[*regexp.Regexp]string) *Page {
+	page := &Page{
+		Title:     replaceTitle(g.Title, regexReplace),
+		Link:      g.Permalinks.Web,
+		CreatedAt: g.CreatedAt,
+		Urgency:   getOncallUrgency(g.Labels),
+	}
+
+	if g.AcknowledgedBy != "" {
+		if u, err := users.get(ctx, g.AcknowledgedBy); err != nil {
+			quality.add(page.Title, page.Link, "could not fetch responder %s: %v", g.AcknowledgedBy, err)
+		} else {
+			page.Responders = append(page.Responders, u.Email)
+		}
+	}
+
+	params := url.Values{}
+	params.Set("alert_group_id", g.ID)
+	next := s.apiURL() + "/api/v1/resolution_notes/?" + params.Encode()
+	for next != "" {
+		var response struct {
+			Next    string                 `json:"next"`
+			Results []oncallResolutionNote `json:"results"`
+		}
+		if err := getJSON(ctx, nil, next, s.header(), &response); err != nil {
+			quality.add(page.Title, page.Link, "could not fetch resolution notes: %v", err)
+			break
+		}
+
+		for _, n := range response.Results {
+			note := PageNote{Content: n.Text}
+			if n.Author != "" {
+				if u, err := users.get(ctx, n.Author); err != nil {
+					quality.add(page.Title, page.Link, "could not fetch note author %s: %v", n.Author, err)
+				} else {
+					note.UserName = u.Username
+					note.UserEmail = u.Email
+				}
+			}
+			page.Notes = append(page.Notes, note)
+		}
+		next = response.Next
+	}
+
+	return page
+}
+
+// listAlertGroups lists the alert groups of the teams started between since and until, oldest first
+func (s *GrafanaOnCallPageSource) listAlertGroups(ctx context.Context, teamIDs []string, since, until time.Time) ([]oncallAlertGroup, error) {
+	// An empty team filter lists the alert groups of all teams
+	if len(teamIDs) == 0 {
+		teamIDs = []string{""}
+	}
+
+	timeFormat := "2006-01-02T15:04:05"
+	var groups []oncallAlertGroup
+	for _, teamID := range teamIDs {
+		params := url.Values{}
+		params.Set("started_at", since.UTC().Format(timeFormat)+"_"+until.UTC().Format(timeFormat))
+		if teamID != "" {
+			params.Set("team_id", teamID)
+		}
+
+		next := s.apiURL() + "/api/v1/alert_groups/?" + params.Encode()
+		for next != "" {
+			var response struct {
+				Next    string             `json:"next"`
+				Results []oncallAlertGroup `json:"results"`
+			}
+			if err := getJSON(ctx, nil, next, s.header(), &response); err != nil {
+				return nil, fmt.Errorf("error listing Grafana OnCall alert groups: %w", err)
+			}
+			groups = append(groups, response.Results...)
+			next = response.Next
+		}
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].CreatedAt.Before(groups[j].CreatedAt)
+	})
+	return groups, nil
+}
+
+// getTeamIds searches for the Grafana OnCall team ids given their team names
+func (s *GrafanaOnCallPageSource) getTeamIds(ctx context.Context) ([]string, error) {
+	teamIDs := make([]string, 0, len(s.Teams))
+	for _, team := range s.Teams {
+		params := url.Values{}
+		params.Set("name", team)
+
+		var response struct {
+			Results []struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"results"`
+		}
+		if err := getJSON(ctx, nil, s.apiURL()+"/api/v1/teams/?"+params.Encode(), s.header(), &response); err != nil {
+			return nil, fmt.Errorf("failed to list teams: %w", err)
+		}
+
+		found := false
+		for _, t := range response.Results {
+			if strings.EqualFold(t.Name, team) {
+				teamIDs = append(teamIDs, t.ID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("team %s not found", team)
+		}
+	}
+	return teamIDs, nil
+}
+
+func (s *GrafanaOnCallPageSource) apiURL() string {
+	return strings.TrimSuffix(s.URL, "/")
+}
+
+func (s *GrafanaOnCallPageSource) header() http.Header {
+	header := http.Header{}
+	header.Set("Authorization", s.Token)
+	return header
+}
+
+// oncallUsers resolves Grafana OnCall user IDs, fetching each user at most once
+type oncallUsers struct {
+	source *GrafanaOnCallPageSource
+
+	mu    sync.Mutex
+	users map[string]oncallUser
+}
+
+func (u *oncallUsers) get(ctx context.Context, id string) (oncallUser, error) {
+	u.mu.Lock()
+	user, ok := u.users[id]
+	u.mu.Unlock()
+	if ok {
+		return user, nil
+	}
+
+	if err := getJSON(ctx, nil, fmt.Sprintf("%s/api/v1/users/%s/", u.source.apiURL(), url.PathEscape(id)), u.source.header(), &user); err != nil {
+		return user, err
+	}
+
+	u.mu.Lock()
+	u.users[id] = user
+	u.mu.Unlock()
+	return user, nil
+}
+
+// oncallUrgencyLabels are the labels holding the urgency of an alert group, by order of preference
+var oncallUrgencyLabels = []string{"severity", "priority", "urgency"}
+
+// getOncallUrgency uses the severity label of an alert group as its urgency, as Prometheus alerts do, falling back to its priority or urgency label
+func getOncallUrgency(labels []oncallLabel) string {
+	for _, name := range oncallUrgencyLabels {
+		for _, l := range labels {
+			if strings.EqualFold(l.Key.Name, name) {
+				return l.Value.Name
+			}
+		}
+	}
+	return ""
+}
+
+// oncallLabelsToTagSet converts alert group labels into "name:value" tags, so that they can be filtered like Datadog tags
+func oncallLabelsToTagSet(labels []oncallLabel) map[string]struct{} {
+	tags := make(map[string]struct{}, len(labels))
+	for _, l := range labels {
+		tags[l.Key.Name+":"+l.Value.Name] = struct{}{}
+	}
+	return tags
+}
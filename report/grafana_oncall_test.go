@@ -0,0 +1,88 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGrafanaOnCallPageSource(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token" {
+			t.Errorf("unexpected Authorization header %q", r.Header.Get("Authorization"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/v1/teams/":
+			w.Write([]byte(`{"results": [{"id": "T1", "name": "DB"}]}`))
+		case "/api/v1/alert_groups/":
+			if r.URL.Query().Get("team_id") != "T1" {
+				t.Errorf("unexpected team_id %q", r.URL.Query().Get("team_id"))
+			}
+			fmt.Fprintf(w, `{"results": [
+				{"id": "G1", "title": "Disk full on db-1", "created_at": "2021-07-20T10:00:00Z", "acknowledged_by": "U1",
+				 "labels": [{"key": {"name": "team"}, "value": {"name": "db"}}, {"key": {"name": "Severity"}, "value": {"name": "critical"}}],
+				 "permalinks": {"web": "%[1]s/alert-groups/G1"}},
+				{"id": "G2", "title": "Replica lag", "created_at": "2021-07-20T11:00:00Z",
+				 "labels": [{"key": {"name": "team"}, "value": {"name": "db"}}, {"key": {"name": "priority"}, "value": {"name": "P3"}}],
+				 "permalinks": {"web": "%[1]s/alert-groups/G2"}},
+				{"id": "G3", "title": "Other team", "created_at": "2021-07-20T12:00:00Z",
+				 "labels": [{"key": {"name": "team"}, "value": {"name": "web"}}]}
+			]}`, server.URL)
+		case "/api/v1/resolution_notes/":
+			if r.URL.Query().Get("alert_group_id") == "G1" {
+				w.Write([]byte(`{"results": [{"author": "U1", "text": "Cleaned up /tmp"}]}`))
+			} else {
+				w.Write([]byte(`{"results": []}`))
+			}
+		case "/api/v1/users/U1/":
+			w.Write([]byte(`{"email": "oncall@example.com", "username": "oncall"}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	source := &GrafanaOnCallPageSource{
+		URL:        server.URL + "/",
+		Token:      "token",
+		Teams:      []string{"db"},
+		TagFilters: []string{"team:db"},
+		Replace:    []string{"/ on db-.*//"},
+	}
+	pages, err := source.FetchPages(context.Background(), time.Date(2021, 7, 14, 0, 0, 0, 0, time.UTC), time.Date(2021, 7, 27, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("FetchPages: %v", err)
+	}
+
+	want := []*Page{
+		{
+			Title:      "Disk full",
+			Link:       server.URL + "/alert-groups/G1",
+			CreatedAt:  time.Date(2021, 7, 20, 10, 0, 0, 0, time.UTC),
+			Urgency:    "critical",
+			Responders: []string{"oncall@example.com"},
+			Notes:      []PageNote{{Content: "Cleaned up /tmp", UserName: "oncall", UserEmail: "oncall@example.com"}},
+		},
+		{
+			Title:     "Replica lag",
+			Link:      server.URL + "/alert-groups/G2",
+			CreatedAt: time.Date(2021, 7, 20, 11, 0, 0, 0, time.UTC),
+			Urgency:   "P3",
+		},
+	}
+	if len(pages) != len(want) {
+		t.Fatalf("got %d pages, want %d", len(pages), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(pages[i], want[i]) {
+			t.Errorf("got page %+v, want %+v", pages[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package report
+
+import (
+	_ "embed"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+//go:embed templates/report.html.tmpl
+var htmlTemplateText string
+
+var htmlTemplate = template.Must(template.New("report.html").Funcs(htmlTemplateFuncs()).Funcs(template.FuncMap{
+	// anchor turns an incident ID into an HTML id, e.g. "#incident-123" into "incident-123"
+	"anchor": anchor,
+}).Parse(htmlTemplateText))
+
+var anchorInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+func anchor(id string) string {
+	return strings.Trim(anchorInvalidChars.ReplaceAllString(id, "-"), "-")
+}
+
+// HTML renders the report as a self-contained HTML document, with embedded CSS and a collapsible section per incident
+func (r *Report) HTML() (string, error) {
+	var b strings.Builder
+	if err := htmlTemplate.Execute(&b, r); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,29 @@
+package report
+
+import "testing"
+
+func TestHTMLGolden(t *testing.T) {
+	withUTC(t)
+
+	html, err := goldenReport().HTML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "report.html", []byte(html))
+}
+
+func TestAnchor(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"#incident-123", "incident-123"},
+		{"INC-1", "INC-1"},
+		{"FH 12 / prod", "FH-12-prod"},
+	}
+	for _, tt := range tests {
+		if got := anchor(tt.id); got != tt.want {
+			t.Errorf("anchor(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
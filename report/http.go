@@ -0,0 +1,102 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// doJSON sends the request built by newRequest using the default retry policy, and decodes the JSON response into out unless it is nil.
+// newRequest is called again for every attempt, so that request bodies can be resent.
+// Requests that aren't idempotent, e.g. creating a page or an issue, are only resent when they were rate limited or never reached the server,
+// so that a request that succeeded but timed out doesn't create duplicates.
+func doJSON(ctx context.Context, client *http.Client, newRequest func(ctx context.Context) (*http.Request, error), out interface{}) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	policy := defaultRetryPolicy
+	idempotent := true
+	policy.retryable = func(err error) bool {
+		if idempotent {
+			return isRetryable(err)
+		}
+		return isRetryableBeforeSent(err)
+	}
+
+	return policy.do(ctx, func(ctx context.Context) error {
+		req, err := newRequest(ctx)
+		if err != nil {
+			return fmt.Errorf("error creating request: %v", err)
+		}
+		idempotent = isIdempotent(req.Method)
+		if req.Header.Get("Accept") == "" {
+			req.Header.Set("Accept", "application/json")
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("error making request: %w", err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("error reading response body: %w", err)
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("%s %s: %w", req.Method, req.URL.Path, &statusError{statusCode: resp.StatusCode, body: string(body)})
+		}
+
+		if out == nil || len(body) == 0 {
+			return nil
+		}
+		if err := json.Unmarshal(body, out); err != nil {
+			return fmt.Errorf("error decoding response of %s %s: %v", req.Method, req.URL.Path, err)
+		}
+		return nil
+	})
+}
+
+// getJSON fetches url with the given headers and decodes the JSON response into out
+func getJSON(ctx context.Context, client *http.Client, url string, header http.Header, out interface{}) error {
+	return doJSON(ctx, client, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		return req, nil
+	}, out)
+}
+
+// postJSON posts body as JSON to url with the given headers, and decodes the JSON response into out unless it is nil
+func postJSON(ctx context.Context, url string, header http.Header, body interface{}, out interface{}) error {
+	return sendJSON(ctx, http.MethodPost, url, header, body, out)
+}
+
+// sendJSON sends body as JSON to url with the given method and headers, and decodes the JSON response into out unless it is nil
+func sendJSON(ctx context.Context, method, url string, header http.Header, body interface{}, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("error marshalling json: %v", err)
+	}
+
+	return doJSON(ctx, nil, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		return req, nil
+	}, out)
+}
@@ -0,0 +1,107 @@
+package report
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withFastRetries shortens the delays of the default retry policy for the duration of a test
+func withFastRetries(t *testing.T) {
+	policy := defaultRetryPolicy
+	defaultRetryPolicy.baseDelay = time.Millisecond
+	defaultRetryPolicy.maxDelay = time.Millisecond
+	t.Cleanup(func() { defaultRetryPolicy = policy })
+}
+
+func TestSendJSONRetries(t *testing.T) {
+	withFastRetries(t)
+
+	tests := []struct {
+		name         string
+		method       string
+		status       int
+		wantAttempts int32
+	}{
+		{"get server error", http.MethodGet, http.StatusBadGateway, 4},
+		{"put server error", http.MethodPut, http.StatusInternalServerError, 4},
+		{"post server error", http.MethodPost, http.StatusInternalServerError, 1},
+		{"patch server error", http.MethodPatch, http.StatusServiceUnavailable, 1},
+		{"post rate limited", http.MethodPost, http.StatusTooManyRequests, 4},
+		{"post client error", http.MethodPost, http.StatusBadRequest, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&attempts, 1)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			if err := sendJSON(context.Background(), tt.method, server.URL, nil, map[string]string{}, nil); err == nil {
+				t.Fatal("expected an error")
+			}
+			if got := atomic.LoadInt32(&attempts); got != tt.wantAttempts {
+				t.Errorf("got %d attempts, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestPostJSONRetriesTimeoutsOnlyBeforeSent(t *testing.T) {
+	withFastRetries(t)
+	defaultRetryPolicy.timeout = 50 * time.Millisecond
+
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		// The page is created, but the response only comes after the client gave up
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	if err := postJSON(context.Background(), server.URL, nil, map[string]string{}, nil); err == nil {
+		t.Fatal("expected an error")
+	}
+	if got := atomic.LoadInt32(&attempts); got != 1 {
+		t.Errorf("got %d attempts, want 1", got)
+	}
+}
+
+func TestIsRetryableBeforeSent(t *testing.T) {
+	// Nothing listens on a port freshly released by a listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	_, dialErr := http.Get("http://" + addr)
+	if dialErr == nil {
+		t.Fatal("expected a connection error")
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection refused", dialErr, true},
+		{"rate limited", &statusError{statusCode: http.StatusTooManyRequests}, true},
+		{"server error", &statusError{statusCode: http.StatusInternalServerError}, false},
+		{"timeout", context.DeadlineExceeded, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableBeforeSent(tt.err); got != tt.want {
+				t.Errorf("isRetryableBeforeSent(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultIncidentIoApiURL is the incident.io API used when none is configured
+	defaultIncidentIoApiURL = "https://api.incident.io"
+	// incidentIoPageSize is the number of incidents requested per page
+	incidentIoPageSize = 250
+)
+
+// IncidentIoIncidentSource fetches incidents from incident.io
+type IncidentIoIncidentSource struct {
+	// incident.io API key
+	ApiKey string
+	// Name of teams, matched against the values of TeamField. All incidents are kept if empty
+	Teams []string
+	// Custom field holding the team of an incident, defaults to "Team"
+	TeamField string
+	// Custom field holding the root cause of an incident, defaults to "Root Cause"
+	RootCauseField string
+	// Custom field describing the impact on customers, defaults to "Customer Impact"
+	CustomerImpactField string
+	// Duration metric measuring how long customers were impacted, defaults to "Customer Impact"
+	CustomerImpactMetric string
+	// Base URL of the incident.io API, defaults to https://api.incident.io
+	ApiURL string
+}
+
+type incidentIoUser struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type incidentIoIncident struct {
+	ID        string    `json:"id"`
+	Reference string    `json:"reference"`
+	Name      string    `json:"name"`
+	Summary   string    `json:"summary"`
+	Permalink string    `json:"permalink"`
+	CreatedAt time.Time `json:"created_at"`
+	Severity  *struct {
+		Name string `json:"name"`
+	} `json:"severity"`
+	RoleAssignments []struct {
+		Role struct {
+			RoleType string `json:"role_type"`
+		} `json:"role"`
+		Assignee *incidentIoUser `json:"assignee"`
+	} `json:"incident_role_assignments"`
+	TimestampValues []struct {
+		Timestamp struct {
+			Name string `json:"name"`
+		} `json:"incident_timestamp"`
+		Value *struct {
+			Value time.Time `json:"value"`
+		} `json:"value"`
+	} `json:"incident_timestamp_values"`
+	DurationMetrics []struct {
+		Metric struct {
+			Name string `json:"name"`
+		} `json:"duration_metric"`
+		ValueSeconds int64 `json:"value_seconds"`
+	} `json:"duration_metrics"`
+	CustomFieldEntries []struct {
+		CustomField struct {
+			Name string `json:"name"`
+		} `json:"custom_field"`
+		Values []struct {
+			ValueText   string `json:"value_text"`
+			ValueOption *struct {
+				Value string `json:"value"`
+			} `json:"value_option"`
+			ValueCatalogEntry *struct {
+				Name string `json:"name"`
+			} `json:"value_catalog_entry"`
+		} `json:"values"`
+	} `json:"custom_field_entries"`
+}
+
+// FetchIncidents implements IncidentSource
+func (s *IncidentIoIncidentSource) FetchIncidents(ctx context.Context, since, until time.Time) ([]*Incident, error) {
+	apiURL := defaultIncidentIoApiURL
+	if s.ApiURL != "" {
+		apiURL = strings.TrimSuffix(s.ApiURL, "/")
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+s.ApiKey)
+
+	params := url.Values{}
+	params.Set("page_size", strconv.Itoa(incidentIoPageSize))
+	params.Set("created_at[gte]", since.UTC().Format("2006-01-02"))
+	params.Set("created_at[lte]", until.UTC().Format("2006-01-02"))
+
+	var incidents []*Incident
+	for {
+		var response struct {
+			Incidents      []incidentIoIncident `json:"incidents"`
+			PaginationMeta struct {
+				After string `json:"after"`
+			} `json:"pagination_meta"`
+		}
+		if err := getJSON(ctx, nil, apiURL+"/v2/incidents?"+params.Encode(), header, &response); err != nil {
+			return nil, fmt.Errorf("Error when listing incident.io incidents: %w", err)
+		}
+
+		for _, i := range response.Incidents {
+			// The date filters are inclusive and day-based, so trim the results to the exact range
+			if i.CreatedAt.Before(since) || !i.CreatedAt.Before(until) {
+				continue
+			}
+			if len(s.Teams) > 0 && !containsAnyFold(s.customField(i, s.TeamField, "Team"), s.Teams) {
+				continue
+			}
+			incidents = append(incidents, s.toIncident(i))
+		}
+
+		if response.PaginationMeta.After == "" || len(response.Incidents) == 0 {
+			break
+		}
+		params.Set("after", response.PaginationMeta.After)
+	}
+
+	sort.Slice(incidents, func(i, j int) bool {
+		return incidents[i].CreatedAt.Before(incidents[j].CreatedAt)
+	})
+	return incidents, nil
+}
+
+// toIncident maps an incident.io incident onto the report's incident
+func (s *IncidentIoIncidentSource) toIncident(i incidentIoIncident) *Incident {
+	incident := &Incident{
+		ID:                  i.Reference,
+		Title:               i.Name,
+		Link:                i.Permalink,
+		Summary:             i.Summary,
+		RootCause:           strings.Join(s.customField(i, s.RootCauseField, "Root Cause"), ", "),
+		CustomerImpactScope: strings.Join(s.customField(i, s.CustomerImpactField, "Customer Impact"), ", "),
+		CreatedAt:           i.CreatedAt,
+	}
+	if i.Severity != nil {
+		incident.Severity = i.Severity.Name
+	}
+
+	for _, r := range i.RoleAssignments {
+		if r.Role.RoleType == "lead" && r.Assignee != nil {
+			incident.Commander = r.Assignee.Name
+			incident.CommanderEmail = r.Assignee.Email
+		}
+	}
+
+	for _, t := range i.TimestampValues {
+		if strings.EqualFold(t.Timestamp.Name, "Resolved at") && t.Value != nil {
+			incident.ResolvedAt = t.Value.Value
+		}
+	}
+
+	metric := s.CustomerImpactMetric
+	if metric == "" {
+		metric = "Customer Impact"
+	}
+	for _, m := range i.DurationMetrics {
+		if strings.EqualFold(m.Metric.Name, metric) {
+			incident.CustomerImpactDuration = time.Duration(m.ValueSeconds) * time.Second
+		}
+	}
+
+	return incident
+}
+
+// customField returns the values of the named custom field of an incident, using fallback as the name if none is given
+func (s *IncidentIoIncidentSource) customField(i incidentIoIncident, name, fallback string) []string {
+	if name == "" {
+		name = fallback
+	}
+
+	var values []string
+	for _, e := range i.CustomFieldEntries {
+		if !strings.EqualFold(e.CustomField.Name, name) {
+			continue
+		}
+		for _, v := range e.Values {
+			switch {
+			case v.ValueText != "":
+				values = append(values, v.ValueText)
+			case v.ValueOption != nil:
+				values = append(values, v.ValueOption.Value)
+			case v.ValueCatalogEntry != nil:
+				values = append(values, v.ValueCatalogEntry.Name)
+			}
+		}
+	}
+	return values
+}
+
+// containsAnyFold tells whether any of the wanted strings is found in values, ignoring case
+func containsAnyFold(values, wanted []string) bool {
+	for _, v := range values {
+		for _, w := range wanted {
+			if strings.EqualFold(v, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
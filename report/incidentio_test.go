@@ -0,0 +1,94 @@
+package report
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIncidentIoIncidentSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/v2/incidents" || r.Header.Get("Authorization") != "Bearer key" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		if query.Get("page_size") != "250" || query.Get("created_at[gte]") != "2021-07-14" || query.Get("created_at[lte]") != "2021-07-27" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch query.Get("after") {
+		case "":
+			w.Write([]byte(`{"incidents": [
+				{"id": "01A", "reference": "INC-1", "name": "Checkout is down", "summary": "Rolled back", "permalink": "https://app.incident.io/incidents/1",
+				 "created_at": "2021-07-20T10:00:00Z", "severity": {"name": "Major"},
+				 "incident_role_assignments": [
+					{"role": {"role_type": "reporter"}, "assignee": {"name": "Reporter", "email": "reporter@example.com"}},
+					{"role": {"role_type": "lead"}, "assignee": {"name": "Ina Commander", "email": "ic@example.com"}}
+				 ],
+				 "incident_timestamp_values": [
+					{"incident_timestamp": {"name": "Reported at"}, "value": {"value": "2021-07-20T10:00:00Z"}},
+					{"incident_timestamp": {"name": "Resolved at"}, "value": {"value": "2021-07-20T11:00:00Z"}}
+				 ],
+				 "duration_metrics": [{"duration_metric": {"name": "Customer impact"}, "value_seconds": 600}],
+				 "custom_field_entries": [
+					{"custom_field": {"name": "Team"}, "values": [{"value_catalog_entry": {"name": "Payments"}}]},
+					{"custom_field": {"name": "Root Cause"}, "values": [{"value_option": {"value": "Bad deploy"}}]},
+					{"custom_field": {"name": "Customer Impact"}, "values": [{"value_text": "All checkouts failed"}]}
+				 ]},
+				{"id": "01B", "reference": "INC-2", "name": "Search is slow", "created_at": "2021-07-21T10:00:00Z",
+				 "custom_field_entries": [{"custom_field": {"name": "Team"}, "values": [{"value_option": {"value": "Search"}}]}]}
+			], "pagination_meta": {"after": "01B"}}`))
+		case "01B":
+			w.Write([]byte(`{"incidents": [
+				{"id": "01C", "reference": "INC-3", "name": "Payments are slow", "permalink": "https://app.incident.io/incidents/3", "created_at": "2021-07-15T08:00:00Z",
+				 "custom_field_entries": [{"custom_field": {"name": "Team"}, "values": [{"value_text": "payments"}]}]},
+				{"id": "01D", "reference": "INC-4", "name": "Late on the last day", "created_at": "2021-07-27T08:00:00Z",
+				 "custom_field_entries": [{"custom_field": {"name": "Team"}, "values": [{"value_text": "payments"}]}]}
+			], "pagination_meta": {}}`))
+		default:
+			t.Errorf("unexpected page %s", r.URL)
+		}
+	}))
+	defer server.Close()
+
+	source := &IncidentIoIncidentSource{
+		ApiKey: "key",
+		Teams:  []string{"payments"},
+		ApiURL: server.URL,
+	}
+	since := time.Date(2021, 7, 14, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2021, 7, 27, 0, 0, 0, 0, time.UTC)
+	incidents, err := source.FetchIncidents(context.Background(), since, until)
+	if err != nil {
+		t.Fatalf("FetchIncidents: %v", err)
+	}
+
+	// Other teams' incidents and those past until are dropped, the others are sorted by creation
+	if len(incidents) != 2 || incidents[0].ID != "INC-3" || incidents[1].ID != "INC-1" {
+		t.Fatalf("got incidents %+v, want INC-3 and INC-1", incidents)
+	}
+	want := &Incident{
+		ID:                     "INC-1",
+		Title:                  "Checkout is down",
+		Link:                   "https://app.incident.io/incidents/1",
+		Severity:               "Major",
+		Commander:              "Ina Commander",
+		CommanderEmail:         "ic@example.com",
+		RootCause:              "Bad deploy",
+		Summary:                "Rolled back",
+		CustomerImpactScope:    "All checkouts failed",
+		CustomerImpactDuration: 10 * time.Minute,
+		CreatedAt:              time.Date(2021, 7, 20, 10, 0, 0, 0, time.UTC),
+		ResolvedAt:             time.Date(2021, 7, 20, 11, 0, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(incidents[1], want) {
+		t.Errorf("got %+v, want %+v", incidents[1], want)
+	}
+	if open := incidents[0]; !open.ResolvedAt.IsZero() || open.Severity != "" || open.Commander != "" {
+		t.Errorf("got open incident %+v", open)
+	}
+}
@@ -0,0 +1,167 @@
+package report
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// jiraLabelInvalidChars matches the characters Jira doesn't allow in labels
+var jiraLabelInvalidChars = regexp.MustCompile(`\s+`)
+
+// JiraRequest describes where to file the follow-ups of a report in Jira
+type JiraRequest struct {
+	// Base URL of Jira, e.g. https://my-company.atlassian.net
+	URL string
+	// Jira username, usually an email
+	Username string
+	// Jira API token
+	Token string
+	// Key of the project to file issues in
+	Project string
+	// Type of the issues to create, defaults to "Task"
+	IssueType string
+	// Create the missing issues. Otherwise, only the issues filed by previous runs are linked to the report
+	Create bool
+	// Report whose incidents to follow up on. Issues are added to its incidents
+	Report *Report
+}
+
+type jiraTracker struct {
+	request JiraRequest
+	// Titles of the issues left to file, when not creating them
+	unfiled []string
+}
+
+// CreateJiraIssues files a Jira issue for every action item of the incidents of the report, or for every incident without action items.
+// Issues filed by a previous run are found again rather than duplicated.
+// Unless request.Create is set, no issue is filed and the titles of the missing ones are returned instead.
+func CreateJiraIssues(ctx context.Context, request JiraRequest) ([]string, error) {
+	t := &jiraTracker{request: request}
+	if err := trackFollowUps(ctx, t, request.Report); err != nil {
+		return nil, err
+	}
+	return t.unfiled, nil
+}
+
+func (t *jiraTracker) ensureIssue(ctx context.Context, f followUp) (Issue, error) {
+	label := jiraLabelInvalidChars.ReplaceAllString(f.key, "_")
+	idLabel := jiraIdLabel(f.id)
+
+	jql := fmt.Sprintf("project = %q AND labels = %q AND (labels = %q OR labels = %q)", t.request.Project, followUpLabel, idLabel, label)
+	found, err := t.search(ctx, jql, idLabel, f.title)
+	if err != nil {
+		return Issue{}, fmt.Errorf("error searching for issues: %w", err)
+	}
+	if found != "" {
+		return t.issue(found, f.title), nil
+	}
+
+	if !t.request.Create {
+		t.unfiled = append(t.unfiled, f.title)
+		return Issue{}, errNotFiled
+	}
+
+	issueType := t.request.IssueType
+	if issueType == "" {
+		issueType = "Task"
+	}
+	fields := map[string]interface{}{
+		"project":     map[string]string{"key": t.request.Project},
+		"issuetype":   map[string]string{"name": issueType},
+		"summary":     f.title,
+		"description": f.description,
+		"labels":      []string{followUpLabel, label, idLabel},
+	}
+
+	var created struct {
+		Key string `json:"key"`
+	}
+	// Version 3 of the API expects descriptions in Atlassian Document Format, version 2 still takes plain text
+	if err := postJSON(ctx, t.apiURL(2)+"/issue", t.header(), map[string]interface{}{"fields": fields}, &created); err != nil {
+		return Issue{}, fmt.Errorf("error creating issue: %w", err)
+	}
+	return t.issue(created.Key, f.title), nil
+}
+
+// search returns the key of the issue matching jql labelled idLabel, or an empty string if there is none.
+// Issues filed before they were labelled with their follow-up id are matched by summary instead.
+// It uses the enhanced search, as Jira Cloud removed GET /rest/api/2/search.
+func (t *jiraTracker) search(ctx context.Context, jql, idLabel, summary string) (string, error) {
+	params := url.Values{}
+	params.Set("jql", jql)
+	params.Set("fields", "summary,labels")
+	params.Set("maxResults", "100")
+
+	bySummary := ""
+	for {
+		var response struct {
+			Issues []struct {
+				Key    string `json:"key"`
+				Fields struct {
+					Summary string   `json:"summary"`
+					Labels  []string `json:"labels"`
+				} `json:"fields"`
+			} `json:"issues"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := getJSON(ctx, nil, t.apiURL(3)+"/search/jql?"+params.Encode(), t.header(), &response); err != nil {
+			return "", err
+		}
+		for _, issue := range response.Issues {
+			switch getJiraIdLabel(issue.Fields.Labels) {
+			case idLabel:
+				return issue.Key, nil
+			case "":
+				if bySummary == "" && issue.Fields.Summary == summary {
+					bySummary = issue.Key
+				}
+			}
+		}
+		if response.NextPageToken == "" {
+			return bySummary, nil
+		}
+		params.Set("nextPageToken", response.NextPageToken)
+	}
+}
+
+// jiraIdLabel renders the label identifying the follow-up an issue was filed for, so that it is found again even once its summary is edited.
+// The id is hashed, as labels can't contain spaces and are limited to 255 characters.
+func jiraIdLabel(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return followUpLabel + "-" + hex.EncodeToString(sum[:8])
+}
+
+// getJiraIdLabel returns the label identifying the follow-up an issue was filed for, or an empty string if it was filed before issues were labelled so
+func getJiraIdLabel(labels []string) string {
+	for _, l := range labels {
+		if strings.HasPrefix(l, followUpLabel+"-") {
+			return l
+		}
+	}
+	return ""
+}
+
+func (t *jiraTracker) issue(key, title string) Issue {
+	return Issue{
+		Key:   key,
+		Title: title,
+		Link:  fmt.Sprintf("%s/browse/%s", strings.TrimSuffix(t.request.URL, "/"), key),
+	}
+}
+
+func (t *jiraTracker) apiURL(version int) string {
+	return fmt.Sprintf("%s/rest/api/%d", strings.TrimSuffix(t.request.URL, "/"), version)
+}
+
+func (t *jiraTracker) header() http.Header {
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(t.request.Username+":"+t.request.Token)))
+	return header
+}
@@ -0,0 +1,163 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// jiraFollowUpReport has an incident with three action items, the first and last of which were filed by previous runs
+func jiraFollowUpReport() *Report {
+	return &Report{
+		Incidents: []*Incident{{
+			ID:       "#incident-1",
+			Title:    "Checkout is down",
+			Link:     "https://app.datadoghq.com/incidents/1",
+			Severity: "SEV-2",
+			FollowUp: "- **Action items**\n  - Add a canary stage\n  - Alert on checkout errors\n  - Page on latency",
+		}},
+	}
+}
+
+func TestCreateJiraIssues(t *testing.T) {
+	tests := []struct {
+		name         string
+		create       bool
+		wantCreated  []string
+		wantUnfiled  []string
+		wantFollowUp string
+		wantIssues   []Issue
+	}{
+		{
+			name:        "search then create",
+			create:      true,
+			wantCreated: []string{"[#incident-1] Alert on checkout errors"},
+			wantFollowUp: "- **Action items**\n" +
+				"  - Add a canary stage ([OPS-1]({jira}/browse/OPS-1))\n" +
+				"  - Alert on checkout errors ([OPS-2]({jira}/browse/OPS-2))\n" +
+				"  - Page on latency ([OPS-3]({jira}/browse/OPS-3))",
+			wantIssues: []Issue{
+				{Key: "OPS-1", Title: "[#incident-1] Add a canary stage", Link: "{jira}/browse/OPS-1"},
+				{Key: "OPS-2", Title: "[#incident-1] Alert on checkout errors", Link: "{jira}/browse/OPS-2"},
+				{Key: "OPS-3", Title: "[#incident-1] Page on latency", Link: "{jira}/browse/OPS-3"},
+			},
+		},
+		{
+			name:        "only link existing issues",
+			wantUnfiled: []string{"[#incident-1] Alert on checkout errors"},
+			wantFollowUp: "- **Action items**\n" +
+				"  - Add a canary stage ([OPS-1]({jira}/browse/OPS-1))\n" +
+				"  - Alert on checkout errors\n" +
+				"  - Page on latency ([OPS-3]({jira}/browse/OPS-3))",
+			wantIssues: []Issue{
+				{Key: "OPS-1", Title: "[#incident-1] Add a canary stage", Link: "{jira}/browse/OPS-1"},
+				{Key: "OPS-3", Title: "[#incident-1] Page on latency", Link: "{jira}/browse/OPS-3"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if user, token, _ := r.BasicAuth(); user != "me@example.com" || token != "token" {
+					t.Errorf("unexpected credentials %s:%s", user, token)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/rest/api/3/search/jql":
+					jql := r.URL.Query().Get("jql")
+					if !strings.HasPrefix(jql, `project = "OPS" AND labels = "incidentist" AND (labels = "incidentist-`) || !strings.HasSuffix(jql, `" OR labels = "#incident-1")`) {
+						t.Errorf("got jql %q, want the issues labelled with the follow-up id or the incident", jql)
+					}
+					// The issues filed by previous runs are on the second page
+					if r.URL.Query().Get("nextPageToken") == "" {
+						fmt.Fprintf(w, `{"issues": [
+							{"key": "OPS-9", "fields": {"summary": "[#incident-1] Follow-up: Checkout is down", "labels": ["incidentist", "#incident-1", %q]}},
+							{"key": "OPS-7", "fields": {"summary": "[#incident-1] Alert on checkout errors", "labels": ["incidentist", "#incident-1", %q]}}
+						], "nextPageToken": "page-2"}`, jiraIdLabel("#incident-1"), jiraIdLabel("#incident-1 Alert on errors"))
+					} else {
+						// OPS-1 was renamed in Jira, OPS-3 was filed before issues were labelled with their follow-up id
+						fmt.Fprintf(w, `{"issues": [
+							{"key": "OPS-1", "fields": {"summary": "Canary stage for checkout", "labels": ["incidentist", "#incident-1", %q]}},
+							{"key": "OPS-3", "fields": {"summary": "[#incident-1] Page on latency", "labels": ["incidentist", "#incident-1"]}}
+						], "isLast": true}`, jiraIdLabel("#incident-1 Add a canary stage"))
+					}
+				case r.Method == http.MethodPost && r.URL.Path == "/rest/api/2/issue":
+					var body struct {
+						Fields struct {
+							Project     map[string]string `json:"project"`
+							IssueType   map[string]string `json:"issuetype"`
+							Summary     string            `json:"summary"`
+							Description string            `json:"description"`
+							Labels      []string          `json:"labels"`
+						} `json:"fields"`
+					}
+					if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+						t.Errorf("error decoding issue: %v", err)
+					}
+					if body.Fields.Project["key"] != "OPS" || body.Fields.IssueType["name"] != "Task" {
+						t.Errorf("got project %v and issue type %v", body.Fields.Project, body.Fields.IssueType)
+					}
+					if want := []string{"incidentist", "#incident-1", jiraIdLabel("#incident-1 Alert on checkout errors")}; !reflect.DeepEqual(body.Fields.Labels, want) {
+						t.Errorf("got labels %v, want %v", body.Fields.Labels, want)
+					}
+					created = append(created, body.Fields.Summary)
+					w.Write([]byte(`{"id": "10002", "key": "OPS-2"}`))
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL)
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+
+			r := jiraFollowUpReport()
+			unfiled, err := CreateJiraIssues(context.Background(), JiraRequest{
+				URL:      server.URL + "/",
+				Username: "me@example.com",
+				Token:    "token",
+				Project:  "OPS",
+				Create:   tt.create,
+				Report:   r,
+			})
+			if err != nil {
+				t.Fatalf("CreateJiraIssues: %v", err)
+			}
+
+			// Issues link to Jira itself rather than its API
+			wantFollowUp := strings.ReplaceAll(tt.wantFollowUp, "{jira}", server.URL)
+			var wantIssues []Issue
+			for _, issue := range tt.wantIssues {
+				issue.Link = strings.ReplaceAll(issue.Link, "{jira}", server.URL)
+				wantIssues = append(wantIssues, issue)
+			}
+
+			if !reflect.DeepEqual(created, tt.wantCreated) {
+				t.Errorf("created %v, want %v", created, tt.wantCreated)
+			}
+			if !reflect.DeepEqual(unfiled, tt.wantUnfiled) {
+				t.Errorf("got unfiled %v, want %v", unfiled, tt.wantUnfiled)
+			}
+			if r.Incidents[0].FollowUp != wantFollowUp {
+				t.Errorf("got follow-up:\n%s\nwant:\n%s", r.Incidents[0].FollowUp, wantFollowUp)
+			}
+			if !reflect.DeepEqual(r.Incidents[0].Issues, wantIssues) {
+				t.Errorf("got issues %v, want %v", r.Incidents[0].Issues, wantIssues)
+			}
+		})
+	}
+}
+
+func TestJiraIdLabel(t *testing.T) {
+	label := jiraIdLabel("page Disk full on db-1")
+	if label != jiraIdLabel("page Disk full on db-1") || label == jiraIdLabel("page Disk full on db-2") {
+		t.Errorf("got label %q, want a stable label per follow-up", label)
+	}
+	if jiraLabelInvalidChars.MatchString(label) || !strings.HasPrefix(label, "incidentist-") {
+		t.Errorf("got label %q, want a valid label starting with incidentist-", label)
+	}
+}
@@ -0,0 +1,321 @@
+package report
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var (
+	// markdownLinkRegex matches the links rendered by link, e.g. "[some description](https://example.com)".
+	// Descriptions may contain brackets, as natively rendered Confluence pages don't escape them in page titles like link does.
+	markdownLinkRegex = regexp.MustCompile(`^\[(.*)\]\(([^)]*)\)$`)
+	// markdownLinkInTextRegex matches the same links anywhere in a line
+	markdownLinkInTextRegex = regexp.MustCompile(`\[([^\]]*)\]\(([^)]*)\)`)
+	// followUpQuestionRegex matches the questions of the follow-up section, e.g. "- **Runbooks**"
+	followUpQuestionRegex = regexp.MustCompile(`^- \*\*[^*]+\*\*$`)
+)
+
+// filledSection holds the lines found under the sections of an incident or page that the team fills out
+type filledSection struct {
+	// ID of the incident, if found
+	id string
+	// Link to the incident or page
+	link        string
+	merged      bool
+	actionTaken []string
+	followUp    []string
+	notes       []string
+}
+
+// MergeMarkdown keeps the sections the team filled out in a previous markdown version of the report:
+// the action taken and follow-up of incidents and pages, and the notes added to pages.
+// Incidents are matched by ID, or by link if the previous report doesn't show their ID, and pages by link. Everything else is left as generated.
+// It returns the incidents and pages that were filled out but could not be matched, so that the text typed for them isn't silently lost.
+func (r *Report) MergeMarkdown(previous string) []string {
+	incidents, pages := parseFilledSections(previous)
+
+	for _, i := range r.Incidents {
+		f := findIncidentSection(incidents, i)
+		if f == nil {
+			continue
+		}
+		f.merged = true
+		i.ActionTaken = filledText(f.actionTaken)
+		i.FollowUp = filledText(f.followUp)
+	}
+
+	for _, p := range r.OtherPages {
+		f, ok := pages[p.Link]
+		if !ok {
+			continue
+		}
+		delete(pages, p.Link)
+		p.ActionTaken = filledText(f.actionTaken)
+		p.FollowUp = filledText(f.followUp)
+
+		// Notes fetched from the paging tool are rendered again, only keep the ones added by hand
+		rendered := make(map[string]struct{}, len(p.Notes))
+		for _, n := range p.Notes {
+			rendered[n.markdown()] = struct{}{}
+		}
+		for _, n := range f.notes {
+			if _, ok := rendered[n]; !ok {
+				p.Notes = append(p.Notes, PageNote{Content: n})
+			}
+		}
+	}
+
+	var unmerged []string
+	for _, f := range incidents {
+		if !f.merged && (filledText(f.actionTaken) != "" || filledText(f.followUp) != "") {
+			if f.id != "" {
+				unmerged = append(unmerged, "incident "+f.id)
+			} else {
+				unmerged = append(unmerged, "incident "+f.link)
+			}
+		}
+	}
+	for link, f := range pages {
+		if filledText(f.actionTaken) != "" || filledText(f.followUp) != "" {
+			unmerged = append(unmerged, "page "+link)
+		}
+	}
+	return unmerged
+}
+
+// findIncidentSection finds the sections of an incident in the previous report
+func findIncidentSection(sections []*filledSection, i *Incident) *filledSection {
+	for _, f := range sections {
+		if f.id != "" && f.id == i.ID {
+			return f
+		}
+	}
+	for _, f := range sections {
+		if f.id == "" && f.link != "" && f.link == i.Link {
+			return f
+		}
+	}
+	return nil
+}
+
+// markdown renders a note the way the built-in template does
+func (n PageNote) markdown() string {
+	if n.UserEmail != "" {
+		return "**" + n.UserEmail + "**: " + n.Content
+	}
+	return n.Content
+}
+
+// parseFilledSections reads the sections filled out by the team from a report rendered with the built-in template, with pages keyed by link
+func parseFilledSections(markdown string) ([]*filledSection, map[string]*filledSection) {
+	var incidents []*filledSection
+	pages := make(map[string]*filledSection)
+
+	var (
+		// Incident or page being read
+		current *filledSection
+		// Lines of the section being read, if it is one the team fills out
+		field        *[]string
+		inOtherPages bool
+		inNotes      bool
+	)
+	for _, line := range strings.Split(markdown, "\n") {
+		switch {
+		case strings.HasPrefix(line, "### "):
+			current, field, inNotes = nil, nil, false
+			heading := strings.TrimPrefix(line, "### ")
+			inOtherPages = heading == "Other Pages"
+			// Incident headings look like "[SEV-2 | #incident-123 | Title | 2021-07-14 @15:04:05](link)".
+			// Natively rendered Confluence pages show the severity as a lozenge before the link instead, which leaves "[#incident-123 | Title | 2021-07-14 @15:04:05](link)".
+			if m := markdownLinkInTextRegex.FindStringSubmatch(heading); m != nil {
+				current = &filledSection{link: m[2]}
+				switch parts := strings.Split(m[1], " | "); len(parts) {
+				case 4:
+					current.id = parts[1]
+				case 3:
+					current.id = parts[0]
+				}
+				incidents = append(incidents, current)
+			}
+		case strings.HasPrefix(line, "#### "):
+			field = nil
+			if current == nil {
+				continue
+			}
+			switch strings.TrimPrefix(line, "#### ") {
+			case "Action taken":
+				field = &current.actionTaken
+			case "Follow-up":
+				field = &current.followUp
+			}
+		case inOtherPages && strings.HasPrefix(line, "- "):
+			current, field, inNotes = nil, nil, false
+			if m := markdownLinkRegex.FindStringSubmatch(strings.TrimPrefix(line, "- ")); m != nil {
+				current = &filledSection{}
+				pages[m[2]] = current
+			}
+		case inOtherPages && current != nil && strings.HasPrefix(line, "  - "):
+			field, inNotes = nil, false
+			item := strings.TrimPrefix(line, "  - ")
+			switch {
+			case strings.HasPrefix(item, "**Action taken**:"):
+				current.actionTaken = []string{strings.TrimPrefix(item, "**Action taken**: ")}
+				field = &current.actionTaken
+			case strings.HasPrefix(item, "**Follow-up**:"):
+				current.followUp = []string{strings.TrimPrefix(item, "**Follow-up**: ")}
+				field = &current.followUp
+			case item == "**Notes**:":
+				inNotes = true
+			}
+		case inNotes && strings.HasPrefix(line, "    - "):
+			current.notes = append(current.notes, strings.TrimPrefix(line, "    - "))
+		case field != nil:
+			*field = append(*field, line)
+		}
+	}
+	return incidents, pages
+}
+
+// filledText joins the lines of a section, or returns an empty string if the section was not filled out
+func filledText(lines []string) string {
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line != "" && line != strings.TrimSpace(filloutPlaceholder) && !followUpQuestionRegex.MatchString(line) {
+			return strings.Join(lines, "\n")
+		}
+	}
+	return ""
+}
+
+// storageToMarkdown turns a page in Confluence storage format back into markdown close enough to the built-in template for parseFilledSections.
+// Tags it doesn't know about, such as macros, are skipped but their text is kept.
+func storageToMarkdown(storage string) (string, error) {
+	d := xml.NewDecoder(strings.NewReader("<root>" + storage + "</root>"))
+	// No AutoClose: xml.HTMLAutoClose matches local names, so it would close the <ac:link> of user mentions right away and fail on </ac:link>.
+	// Storage format is XHTML, void elements such as <br/> are already closed.
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+
+	var (
+		b          strings.Builder
+		listDepth  int
+		inListItem int
+		hrefs      []string
+		// Macro parameters, such as the colour of a status lozenge, aren't part of the text of the page
+		inParameter int
+	)
+	newline := func() {
+		if s := b.String(); s != "" && !strings.HasSuffix(s, "\n") {
+			b.WriteString("\n")
+		}
+	}
+
+	for {
+		token, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "parameter" || inParameter > 0 {
+				inParameter++
+				continue
+			}
+			switch t.Name.Local {
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				newline()
+				b.WriteString(strings.Repeat("#", int(t.Name.Local[1]-'0')) + " ")
+			case "p":
+				// Paragraphs following the first one of a list item are indented under it
+				if inListItem > 0 && strings.HasSuffix(b.String(), "\n") {
+					b.WriteString(strings.Repeat("  ", listDepth))
+				}
+			case "ul", "ol":
+				newline()
+				listDepth++
+			case "li":
+				newline()
+				b.WriteString(strings.Repeat("  ", listDepth-1) + "- ")
+				inListItem++
+			case "a":
+				href := ""
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "href" {
+						href = attr.Value
+					}
+				}
+				hrefs = append(hrefs, href)
+				b.WriteString("[")
+			case "strong", "b":
+				b.WriteString("**")
+			case "em", "i":
+				b.WriteString("_")
+			case "code":
+				b.WriteString("`")
+			case "br":
+				b.WriteString("\n")
+			}
+		case xml.EndElement:
+			if inParameter > 0 {
+				inParameter--
+				continue
+			}
+			switch t.Name.Local {
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				b.WriteString("\n\n")
+			case "p":
+				newline()
+				if inListItem == 0 {
+					b.WriteString("\n")
+				}
+			case "ul", "ol":
+				listDepth--
+				if listDepth == 0 {
+					newline()
+					b.WriteString("\n")
+				}
+			case "li":
+				newline()
+				inListItem--
+			case "a":
+				if n := len(hrefs); n > 0 {
+					b.WriteString("](" + hrefs[n-1] + ")")
+					hrefs = hrefs[:n-1]
+				}
+			case "strong", "b":
+				b.WriteString("**")
+			case "em", "i":
+				b.WriteString("_")
+			case "code":
+				b.WriteString("`")
+			}
+		case xml.CharData:
+			if inParameter > 0 {
+				continue
+			}
+			// Skip the line breaks between blocks, but keep those within paragraphs
+			if strings.TrimSpace(string(t)) == "" {
+				s := b.String()
+				if s == "" || strings.HasSuffix(s, "\n") || strings.HasSuffix(s, "- ") {
+					continue
+				}
+			}
+			b.Write(t)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,133 @@
+package report
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fillOut edits a report rendered with the built-in template the way a team would, replacing the first occurrence of each section
+func fillOut(t *testing.T, markdown string, edits ...string) string {
+	t.Helper()
+	for i := 0; i < len(edits); i += 2 {
+		if !strings.Contains(markdown, edits[i]) {
+			t.Fatalf("report has no %q to fill out", edits[i])
+		}
+		markdown = strings.Replace(markdown, edits[i], edits[i+1], 1)
+	}
+	return markdown
+}
+
+func TestMergeMarkdownRoundTrip(t *testing.T) {
+	withUTC(t)
+
+	filled := fillOut(t, renderMarkdown(t, goldenReport()),
+		"#### Action taken\n\n  _TODO: please fill out_",
+		"#### Action taken\n\n  Rolled back checkout-api\n  and paused deploys",
+		"- **Action items**\n  _TODO: please fill out_",
+		"- **Action items**\n  - Add a canary stage",
+		"  - **Action taken**:   _TODO: please fill out_",
+		"  - **Action taken**: Freed disk space",
+		"    - Again\n",
+		"    - Again\n    - Added a disk alert\n",
+	)
+
+	// A new run fetches the same data again, without anything the team typed
+	r := goldenReport()
+	if unmerged := r.MergeMarkdown(filled); len(unmerged) != 0 {
+		t.Errorf("got unmerged sections %v, want none", unmerged)
+	}
+
+	checkout := r.Incidents[0]
+	if want := "  Rolled back checkout-api\n  and paused deploys"; checkout.ActionTaken != want {
+		t.Errorf("got action taken %q, want %q", checkout.ActionTaken, want)
+	}
+	if !strings.Contains(checkout.FollowUp, "- **Action items**\n  - Add a canary stage") {
+		t.Errorf("got follow-up %q, want the action items filled out", checkout.FollowUp)
+	}
+	if search := r.Incidents[1]; search.ActionTaken != "" || search.FollowUp != "" {
+		t.Errorf("got action taken %q and follow-up %q for an incident left as is, want none", search.ActionTaken, search.FollowUp)
+	}
+
+	disk := r.OtherPages[0]
+	if disk.ActionTaken != "Freed disk space" {
+		t.Errorf("got page action taken %q, want %q", disk.ActionTaken, "Freed disk space")
+	}
+	wantNotes := []PageNote{{Content: "Cleaned up /tmp", UserEmail: "db@example.com"}, {Content: "Again"}, {Content: "Added a disk alert"}}
+	if !reflect.DeepEqual(disk.Notes, wantNotes) {
+		t.Errorf("got notes %v, want %v", disk.Notes, wantNotes)
+	}
+
+	// Rendering the merged report gives back what the team filled out, so that merging again is a no-op
+	if got := renderMarkdown(t, r); got != filled {
+		t.Errorf("merged report differs from the filled out one.\ngot:\n%s\nwant:\n%s", got, filled)
+	}
+}
+
+func TestMergeMarkdownUnmerged(t *testing.T) {
+	withUTC(t)
+
+	filled := fillOut(t, renderMarkdown(t, goldenReport()),
+		"#### Action taken\n\n  _TODO: please fill out_",
+		"#### Action taken\n\n  Rolled back checkout-api",
+		"#### Action taken\n\n  _TODO: please fill out_",
+		"#### Action taken\n\n  Added an index",
+		"- [2021-07-22 @12:00:00 Certificate expiring](https://acme.pagerduty.com/incidents/Q4)\n  - **Ack'ed by**: \n  - **Action taken**:   _TODO: please fill out_",
+		"- [2021-07-22 @12:00:00 Certificate expiring](https://acme.pagerduty.com/incidents/Q4)\n  - **Ack'ed by**: \n  - **Action taken**: Renewed it",
+	)
+
+	// The second incident and the certificate page are gone from the new run, e.g. after narrowing the report down
+	r := goldenReport()
+	r.Incidents = r.Incidents[:1]
+	r.OtherPages = r.OtherPages[:1]
+
+	want := []string{"incident #incident-2", "page https://acme.pagerduty.com/incidents/Q4"}
+	if got := r.MergeMarkdown(filled); !reflect.DeepEqual(got, want) {
+		t.Errorf("got unmerged sections %v, want %v", got, want)
+	}
+	if r.Incidents[0].ActionTaken != "  Rolled back checkout-api" {
+		t.Errorf("got action taken %q", r.Incidents[0].ActionTaken)
+	}
+}
+
+func TestMergeMarkdownByLink(t *testing.T) {
+	// Custom templates may leave out the incident ID from headings, incidents are then matched by link
+	previous := `### [Checkout is down](https://app.datadoghq.com/incidents/1)
+
+#### Action taken
+
+  Rolled back checkout-api
+
+### Other Pages
+`
+	r := goldenReport()
+	if unmerged := r.MergeMarkdown(previous); len(unmerged) != 0 {
+		t.Errorf("got unmerged sections %v, want none", unmerged)
+	}
+	if r.Incidents[0].ActionTaken != "  Rolled back checkout-api" {
+		t.Errorf("got action taken %q", r.Incidents[0].ActionTaken)
+	}
+	if r.Incidents[1].ActionTaken != "" {
+		t.Errorf("got action taken %q for another incident", r.Incidents[1].ActionTaken)
+	}
+}
+
+func TestFilledText(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  string
+	}{
+		{"empty", nil, ""},
+		{"placeholder", []string{"", filloutPlaceholder, ""}, ""},
+		{"unanswered questions", []string{"- **Runbooks**", filloutPlaceholder, "", "- **Related PRs**", filloutPlaceholder}, ""},
+		{"filled out", []string{"", "  Rolled back", "  and paused deploys", ""}, "  Rolled back\n  and paused deploys"},
+		{"one question answered", []string{"- **Runbooks**", "  https://wiki/runbook", "", "- **Related PRs**", filloutPlaceholder},
+			"- **Runbooks**\n  https://wiki/runbook\n\n- **Related PRs**\n" + filloutPlaceholder},
+	}
+	for _, tt := range tests {
+		if got := filledText(tt.lines); got != tt.want {
+			t.Errorf("%s: filledText() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package report
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Report holds the incidents and pages of a team over a time range, with pages associated to the incidents they fired during
+type Report struct {
+	// Title of the report
+	Title string `json:"title"`
+	// Name of the teams the report is for
+	Teams []string `json:"teams"`
+	// Start date of the report, in the format "YYYY-MM-DD"
+	Since string `json:"since"`
+	// End date of the report, in the format "YYYY-MM-DD"
+	Until string `json:"until"`
+	// Incidents declared during the report, oldest first
+	Incidents []*Incident `json:"incidents"`
+	// Pages not associated with any incident, oldest first
+	OtherPages []*Page `json:"other_pages"`
+	// Number of incidents declared during the report
+	TotalIncidents int `json:"total_incidents"`
+	// Number of pages fired during the report, including those associated with incidents
+	TotalPages int `json:"total_pages"`
+	// Pages or incidents for which some data could not be fetched
+	DataIssues []DataIssue `json:"data_issues,omitempty"`
+}
+
+// JSON renders the report as indented JSON
+func (r *Report) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// Incident is an incident declared by a team, along with the pages that fired while it was ongoing
+type Incident struct {
+	// Identifier of the incident, e.g. "#incident-123"
+	ID string `json:"id"`
+	// Title of the incident
+	Title string `json:"title"`
+	// Link to the incident
+	Link string `json:"link"`
+	// Severity of the incident, e.g. "SEV-2"
+	Severity string `json:"severity"`
+	// Name of the incident commander
+	Commander string `json:"commander"`
+	// Email of the incident commander
+	CommanderEmail string `json:"commander_email"`
+	// Root cause of the incident
+	RootCause string `json:"root_cause"`
+	// Summary of the incident
+	Summary string `json:"summary"`
+	// Description of the impact on customers, empty if there was none
+	CustomerImpactScope string `json:"customer_impact_scope"`
+	// How long customers were impacted
+	CustomerImpactDuration time.Duration `json:"-"`
+	// When the incident was declared
+	CreatedAt time.Time `json:"created_at"`
+	// When the incident was resolved, zero if it is still open
+	ResolvedAt time.Time `json:"-"`
+	// IDs of the PagerDuty incidents the incident was declared from, as recorded in the incident
+	PageIDs []string `json:"page_ids,omitempty"`
+	// IDs of the PagerDuty alerts the incident was declared from, as recorded in the incident
+	AlertIDs []string `json:"alert_ids,omitempty"`
+	// IDs of the Datadog monitors the incident was declared from, as recorded in the incident
+	MonitorIDs []string `json:"monitor_ids,omitempty"`
+	// Services and teams impacted by the incident
+	Services []string `json:"services,omitempty"`
+	// Tags of the incident, in the form "name:value"
+	Tags []string `json:"tags,omitempty"`
+	// Pages that fired while the incident was ongoing, filled in when generating the report
+	Pages []*Page `json:"pages"`
+	// Actions taken during the incident, as filled out by the team in a previous report
+	ActionTaken string `json:"action_taken,omitempty"`
+	// Follow-up section of the incident, as filled out by the team in a previous report
+	FollowUp string `json:"follow_up,omitempty"`
+	// Issues tracking the follow-up of the incident
+	Issues []Issue `json:"issues,omitempty"`
+}
+
+// MarshalJSON renders the customer impact duration in seconds, and omits the resolution time of open incidents
+func (i *Incident) MarshalJSON() ([]byte, error) {
+	type incident Incident
+	out := struct {
+		*incident
+		CustomerImpactSeconds float64    `json:"customer_impact_seconds"`
+		ResolvedAt            *time.Time `json:"resolved_at,omitempty"`
+	}{
+		incident:              (*incident)(i),
+		CustomerImpactSeconds: i.CustomerImpactDuration.Seconds(),
+	}
+	if !i.ResolvedAt.IsZero() {
+		out.ResolvedAt = &i.ResolvedAt
+	}
+	return json.Marshal(out)
+}
+
+// Issue is a ticket in an issue tracker, following up on an incident or a recurring page
+type Issue struct {
+	// Key of the issue, e.g. "OPS-123" in Jira or "#123" in GitHub
+	Key string `json:"key"`
+	// Title of the issue
+	Title string `json:"title,omitempty"`
+	// Link to the issue
+	Link string `json:"link"`
+}
+
+// PageNote is a note left on a page by a responder
+type PageNote struct {
+	// Text of the note
+	Content string `json:"content"`
+	// Name of the author of the note
+	UserName string `json:"user_name,omitempty"`
+	// Email of the author of the note
+	UserEmail string `json:"user_email,omitempty"`
+}
+
+// Page is an alert that paged the team
+type Page struct {
+	// Title of the page
+	Title string `json:"title"`
+	// Link to the page
+	Link string `json:"link"`
+	// Identifier of the page in the pager, e.g. the PagerDuty incident ID
+	ID string `json:"id,omitempty"`
+	// IDs of the alerts grouped under the page
+	AlertIDs []string `json:"alert_ids,omitempty"`
+	// IDs of the Datadog monitors that triggered the page
+	MonitorIDs []string `json:"monitor_ids,omitempty"`
+	// Service the page was triggered on, e.g. the PagerDuty service
+	Service string `json:"service,omitempty"`
+	// Tags of the alerts grouped under the page
+	Tags []string `json:"tags,omitempty"`
+	// When the page fired
+	CreatedAt time.Time `json:"created_at"`
+	// Urgency or priority of the page, e.g. "high" in PagerDuty or "P1" in Opsgenie
+	Urgency string `json:"urgency"`
+	// IDs of the incidents the page is associated with, filled in when generating the report
+	IncidentIDs []string `json:"incident_ids"`
+	// How the page was associated with each of its incidents, filled in when generating the report
+	Correlations []Correlation `json:"correlations,omitempty"`
+	// Emails of the users who responded to the page
+	Responders []string `json:"responders"`
+	// Notes left on the page
+	Notes []PageNote `json:"notes"`
+	// Actions taken for the page, as filled out by the team in a previous report
+	ActionTaken string `json:"action_taken,omitempty"`
+	// Follow-up of the page, as filled out by the team in a previous report
+	FollowUp string `json:"follow_up,omitempty"`
+	// Issues tracking the follow-up of the page, when it keeps firing
+	Issues []Issue `json:"issues,omitempty"`
+}
@@ -0,0 +1,284 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultOpsgenieApiURL is the Opsgenie API used when none is configured
+	defaultOpsgenieApiURL = "https://api.opsgenie.com"
+	// opsgeniePageLimit is the maximum number of results Opsgenie returns per request
+	opsgeniePageLimit = 100
+	// opsgenieMaxOffset is the ceiling Opsgenie puts on offset+limit when listing alerts
+	opsgenieMaxOffset = 20000
+)
+
+// OpsgeniePageSource fetches pages from Opsgenie alerts
+type OpsgeniePageSource struct {
+	// Name of Opsgenie teams
+	Teams []string
+	// Only keep alerts carrying one of these tags
+	TagFilters []string
+	// Opsgenie API key
+	ApiKey string
+	// Alert priorities to keep: "high" for P1 and P2, "low" for P3 to P5, or a comma separated list such as "P1,P2"
+	Urgency string
+	// Replacement regexes to apply to alert messages, in the form "/regex/replacement/"
+	Replace []string
+	// Number of alerts to fetch details for concurrently, defaults to 4
+	Concurrency int
+	// Base URL of the Opsgenie API, defaults to https://api.opsgenie.com. EU accounts use https://api.eu.opsgenie.com
+	ApiURL string
+}
+
+type opsgenieAlert struct {
+	ID        string    `json:"id"`
+	TinyID    string    `json:"tinyId"`
+	Message   string    `json:"message"`
+	Priority  string    `json:"priority"`
+	Tags      []string  `json:"tags"`
+	CreatedAt time.Time `json:"createdAt"`
+	Report    struct {
+		AcknowledgedBy string `json:"acknowledgedBy"`
+	} `json:"report"`
+}
+
+type opsgenieLog struct {
+	Log       string    `json:"log"`
+	Type      string    `json:"type"`
+	Owner     string    `json:"owner"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type opsgenieNote struct {
+	Note      string    `json:"note"`
+	Owner     string    `json:"owner"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type opsgeniePaging struct {
+	Next string `json:"next"`
+}
+
+// FetchPages implements PageSource
+func (s *OpsgeniePageSource) FetchPages(ctx context.Context, since, until time.Time) ([]*Page, error) {
+	regexReplace, err := getRegexReplace(s.Replace)
+	if err != nil {
+		return nil, err
+	}
+
+	alerts, err := s.listAlerts(ctx, since, until)
+	if err != nil {
+		return nil, err
+	}
+
+	var quality dataQuality
+	results := make([]*Page, len(alerts))
+	err = forEachConcurrently(len(alerts), s.Concurrency, func(i int) error {
+		a := alerts[i]
+		if len(s.TagFilters) > 0 && !matchesTagFilters(toTagSet(a.Tags), s.TagFilters) {
+			return nil
+		}
+		results[i] = s.fetchPage(ctx, &quality, a, regexReplace)
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []*Page
+	for _, p := range results {
+		if p != nil {
+			pages = append(pages, p)
+		}
+	}
+	return pages, quality.err()
+}
+
+// fetchPage enriches a single alert with its notes and the users who acknowledged it
+func (s *OpsgeniePageSource) fetchPage(ctx context.Context, quality *dataQuality, a opsgenieAlert, regexReplace map[*regexp.Regexp]string) *Page {
+	page := &Page{
+		Title:     replaceTitle(a.Message, regexReplace),
+		Link:      fmt.Sprintf("%s/alert/detail/%s/details", s.appURL(), a.ID),
+		CreatedAt: a.CreatedAt,
+		Urgency:   a.Priority,
+	}
+
+	params := url.Values{}
+	params.Set("identifierType", "id")
+	params.Set("limit", strconv.Itoa(opsgeniePageLimit))
+	params.Set("order", "asc")
+
+	next := fmt.Sprintf("%s/v2/alerts/%s/notes?%s", s.apiURL(), url.PathEscape(a.ID), params.Encode())
+	for next != "" {
+		var response struct {
+			Data   []opsgenieNote `json:"data"`
+			Paging opsgeniePaging `json:"paging"`
+		}
+		if err := getJSON(ctx, nil, next, s.header(), &response); err != nil {
+			quality.add(page.Title, page.Link, "could not fetch notes: %v", err)
+			break
+		}
+		for _, n := range response.Data {
+			page.Notes = append(page.Notes, PageNote{
+				Content:   n.Note,
+				UserEmail: n.Owner,
+			})
+		}
+		next = response.Paging.Next
+	}
+
+	// Users who acknowledged the alert are its responders. The alert only records the first one, the others are found in its logs.
+	seen := make(map[string]struct{})
+	if a.Report.AcknowledgedBy != "" {
+		seen[a.Report.AcknowledgedBy] = struct{}{}
+		page.Responders = append(page.Responders, a.Report.AcknowledgedBy)
+	}
+	next = fmt.Sprintf("%s/v2/alerts/%s/logs?%s", s.apiURL(), url.PathEscape(a.ID), params.Encode())
+	for next != "" {
+		var response struct {
+			Data   []opsgenieLog  `json:"data"`
+			Paging opsgeniePaging `json:"paging"`
+		}
+		if err := getJSON(ctx, nil, next, s.header(), &response); err != nil {
+			quality.add(page.Title, page.Link, "could not fetch responders: %v", err)
+			break
+		}
+		for _, l := range response.Data {
+			if !isOpsgenieAcknowledgement(l) || l.Owner == "" {
+				continue
+			}
+			if _, ok := seen[l.Owner]; ok {
+				continue
+			}
+			seen[l.Owner] = struct{}{}
+			page.Responders = append(page.Responders, l.Owner)
+		}
+		next = response.Paging.Next
+	}
+
+	return page
+}
+
+// isOpsgenieAcknowledgement tells whether a log entry records a user acknowledging the alert, e.g. "Alert acknowledged via web".
+// Opsgenie logs these with the generic "system" type, so only the text tells them apart.
+func isOpsgenieAcknowledgement(l opsgenieLog) bool {
+	log := strings.ToLower(l.Log)
+	return strings.Contains(log, "acknowledged") && !strings.Contains(log, "unacknowledged")
+}
+
+// listAlerts lists the alerts of the teams created between since and until, oldest first
+func (s *OpsgeniePageSource) listAlerts(ctx context.Context, since, until time.Time) ([]opsgenieAlert, error) {
+	params := url.Values{}
+	params.Set("query", getOpsgenieQuery(s.Teams, getOpsgeniePriorities(s.Urgency), since, until))
+	params.Set("limit", strconv.Itoa(opsgeniePageLimit))
+	params.Set("sort", "createdAt")
+	params.Set("order", "asc")
+
+	var alerts []opsgenieAlert
+	for offset := 0; ; offset += opsgeniePageLimit {
+		if offset+opsgeniePageLimit > opsgenieMaxOffset {
+			fmt.Fprintf(os.Stderr, "WARN: too many Opsgenie alerts between %s and %s, the report may be incomplete\n", since.Format(time.RFC3339), until.Format(time.RFC3339))
+			break
+		}
+		params.Set("offset", strconv.Itoa(offset))
+
+		var response struct {
+			Data []opsgenieAlert `json:"data"`
+		}
+		if err := getJSON(ctx, nil, s.apiURL()+"/v2/alerts?"+params.Encode(), s.header(), &response); err != nil {
+			return nil, fmt.Errorf("error listing Opsgenie alerts: %w", err)
+		}
+		alerts = append(alerts, response.Data...)
+		if len(response.Data) < opsgeniePageLimit {
+			break
+		}
+	}
+
+	sort.SliceStable(alerts, func(i, j int) bool {
+		return alerts[i].CreatedAt.Before(alerts[j].CreatedAt)
+	})
+	return alerts, nil
+}
+
+func (s *OpsgeniePageSource) apiURL() string {
+	if s.ApiURL == "" {
+		return defaultOpsgenieApiURL
+	}
+	return strings.TrimSuffix(s.ApiURL, "/")
+}
+
+// appURL returns the URL of the Opsgenie web application matching the API, e.g. https://app.eu.opsgenie.com
+func (s *OpsgeniePageSource) appURL() string {
+	return strings.Replace(s.apiURL(), "://api.", "://app.", 1)
+}
+
+func (s *OpsgeniePageSource) header() http.Header {
+	header := http.Header{}
+	header.Set("Authorization", "GenieKey "+s.ApiKey)
+	return header
+}
+
+// getOpsgenieQuery builds the alert search query for the given teams, priorities and time range
+func getOpsgenieQuery(teams, priorities []string, since, until time.Time) string {
+	clauses := []string{
+		fmt.Sprintf("createdAt >= %d", since.UnixNano()/int64(time.Millisecond)),
+		fmt.Sprintf("createdAt < %d", until.UnixNano()/int64(time.Millisecond)),
+	}
+
+	if len(teams) > 0 {
+		teamClauses := make([]string, 0, len(teams))
+		for _, t := range teams {
+			teamClauses = append(teamClauses, fmt.Sprintf("teams: %q", t))
+		}
+		clauses = append(clauses, "("+strings.Join(teamClauses, " OR ")+")")
+	}
+
+	if len(priorities) > 0 {
+		priorityClauses := make([]string, 0, len(priorities))
+		for _, p := range priorities {
+			priorityClauses = append(priorityClauses, "priority: "+p)
+		}
+		clauses = append(clauses, "("+strings.Join(priorityClauses, " OR ")+")")
+	}
+
+	return strings.Join(clauses, " AND ")
+}
+
+// getOpsgeniePriorities maps a PagerDuty style urgency to Opsgenie priorities
+func getOpsgeniePriorities(urgency string) []string {
+	switch strings.ToLower(urgency) {
+	case "":
+		return nil
+	case "high":
+		return []string{"P1", "P2"}
+	case "low":
+		return []string{"P3", "P4", "P5"}
+	}
+
+	var priorities []string
+	for _, p := range strings.Split(urgency, ",") {
+		if p = strings.ToUpper(strings.TrimSpace(p)); p != "" {
+			priorities = append(priorities, p)
+		}
+	}
+	return priorities
+}
+
+// toTagSet converts a list of tags into a set
+func toTagSet(tags []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(tags))
+	for _, t := range tags {
+		set[strings.TrimSpace(t)] = struct{}{}
+	}
+	return set
+}
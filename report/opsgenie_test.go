@@ -0,0 +1,95 @@
+package report
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOpsgeniePageSource(t *testing.T) {
+	logs, err := os.ReadFile("testdata/opsgenie_alert_logs.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "GenieKey key" {
+			t.Errorf("unexpected Authorization header %q", r.Header.Get("Authorization"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v2/alerts":
+			query = r.URL.Query().Get("query")
+			w.Write([]byte(`{"data": [
+				{"id": "alert-1", "tinyId": "1", "message": "Disk full on db-1", "priority": "P2", "tags": ["team:db"], "createdAt": "2021-07-20T10:00:00.000Z",
+				 "report": {"ackTime": 120000, "acknowledgedBy": "first@example.com"}},
+				{"id": "alert-2", "tinyId": "2", "message": "Other team", "priority": "P1", "tags": ["team:web"], "createdAt": "2021-07-20T09:00:00.000Z"}
+			]}`))
+		case "/v2/alerts/alert-1/notes":
+			w.Write([]byte(`{"data": [{"note": "Cleaned up /tmp", "owner": "second@example.com", "createdAt": "2021-07-20T10:10:00.000Z"}], "paging": {}}`))
+		case "/v2/alerts/alert-1/logs":
+			w.Write(logs)
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	source := &OpsgeniePageSource{
+		Teams:      []string{"db"},
+		TagFilters: []string{"team:db"},
+		ApiKey:     "key",
+		Urgency:    "high",
+		Replace:    []string{"/ on db-.*//"},
+		ApiURL:     server.URL,
+	}
+	since := time.Date(2021, 7, 14, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2021, 7, 27, 0, 0, 0, 0, time.UTC)
+	pages, err := source.FetchPages(context.Background(), since, until)
+	if err != nil {
+		t.Fatalf("FetchPages: %v", err)
+	}
+
+	wantQuery := `createdAt >= 1626220800000 AND createdAt < 1627344000000 AND (teams: "db") AND (priority: P1 OR priority: P2)`
+	if query != wantQuery {
+		t.Errorf("got query %q, want %q", query, wantQuery)
+	}
+
+	if len(pages) != 1 {
+		t.Fatalf("got %d pages, want 1", len(pages))
+	}
+	page := pages[0]
+	if page.Title != "Disk full" || page.Urgency != "P2" || page.Link != server.URL+"/alert/detail/alert-1/details" {
+		t.Errorf("got page %+v", page)
+	}
+	if want := []string{"first@example.com", "second@example.com"}; !reflect.DeepEqual(page.Responders, want) {
+		t.Errorf("got responders %v, want %v", page.Responders, want)
+	}
+	if want := []PageNote{{Content: "Cleaned up /tmp", UserEmail: "second@example.com"}}; !reflect.DeepEqual(page.Notes, want) {
+		t.Errorf("got notes %v, want %v", page.Notes, want)
+	}
+}
+
+func TestIsOpsgenieAcknowledgement(t *testing.T) {
+	tests := []struct {
+		log  string
+		want bool
+	}{
+		{"Alert acknowledged via web", true},
+		{"Alert acknowledged via mobile", true},
+		{"Alert unacknowledged via web", false},
+		{"Viewed on [web]", false},
+		{"Alert closed via web", false},
+	}
+	for _, tt := range tests {
+		if got := isOpsgenieAcknowledgement(opsgenieLog{Log: tt.log}); got != tt.want {
+			t.Errorf("isOpsgenieAcknowledgement(%q) = %v, want %v", tt.log, got, tt.want)
+		}
+	}
+}
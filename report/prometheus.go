@@ -0,0 +1,188 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultPrometheusStep is the resolution alert history is queried at when none is configured
+	defaultPrometheusStep = time.Minute
+	// prometheusMaxPoints is the maximum number of points Prometheus returns per series for a range query
+	prometheusMaxPoints = 11000
+)
+
+// PrometheusPageSource fetches pages from the history of the alerts evaluated by Prometheus, which it records in the ALERTS series.
+// These are the alerts Alertmanager routes, and any server implementing the Prometheus query API, e.g. Thanos or Grafana Mimir, keeps them too.
+type PrometheusPageSource struct {
+	// Base URL of the Prometheus server, e.g. http://prometheus:9090
+	URL string
+	// PromQL label matchers selecting the alerts of the team, e.g. `team="my-team"`
+	Matchers []string
+	// Only keep alerts with one of these labels, in the form "name:value"
+	TagFilters []string
+	// Replacement regexes to apply to alert names, in the form "/regex/replacement/"
+	Replace []string
+	// Resolution of the alert history, defaults to one minute. Alerts firing for less than that may be missed.
+	Step time.Duration
+	// Optional value of the Authorization header, e.g. "Bearer <token>"
+	Authorization string
+}
+
+// prometheusSeries is a series of a range query result, its values being pairs of a Unix timestamp and a sample value
+type prometheusSeries struct {
+	Metric map[string]string `json:"metric"`
+	Values [][2]interface{}  `json:"values"`
+}
+
+// FetchPages implements PageSource
+func (s *PrometheusPageSource) FetchPages(ctx context.Context, since, until time.Time) ([]*Page, error) {
+	regexReplace, err := getRegexReplace(s.Replace)
+	if err != nil {
+		return nil, err
+	}
+	step := s.Step
+	if step <= 0 {
+		step = defaultPrometheusStep
+	}
+
+	query := "ALERTS{" + strings.Join(append([]string{`alertstate="firing"`}, s.Matchers...), ",") + "}"
+
+	// Alerts firing a step before since started before the report, and are told apart from those starting at since
+	labels := make(map[string]map[string]string)
+	samples := make(map[string][]time.Time)
+	for start := since.Add(-step); start.Before(until); {
+		end := start.Add(step * (prometheusMaxPoints - 1))
+		if end.After(until) {
+			end = until
+		}
+
+		result, err := s.queryRange(ctx, query, start, end, step)
+		if err != nil {
+			return nil, fmt.Errorf("error querying Prometheus alerts: %w", err)
+		}
+		for _, series := range result {
+			key := getPrometheusSelector(series.Metric)
+			labels[key] = series.Metric
+			for _, v := range series.Values {
+				if ts, ok := v[0].(float64); ok {
+					samples[key] = append(samples[key], time.Unix(0, int64(math.Round(ts*1e3))*int64(time.Millisecond)).UTC())
+				}
+			}
+		}
+		start = end.Add(step)
+	}
+
+	var pages []*Page
+	for key, times := range samples {
+		alertLabels := labels[key]
+		if len(s.TagFilters) > 0 && !matchesTagFilters(labelsToTagSet(alertLabels), s.TagFilters) {
+			continue
+		}
+		for _, firing := range getPrometheusFirings(times, step) {
+			if firing[0].Before(since) || !firing[0].Before(until) {
+				continue
+			}
+			pages = append(pages, &Page{
+				Title:     replaceTitle(alertLabels["alertname"], regexReplace),
+				Link:      s.graphURL(key, firing[0], firing[1]),
+				CreatedAt: firing[0],
+				Urgency:   alertLabels["severity"],
+			})
+		}
+	}
+
+	sort.SliceStable(pages, func(i, j int) bool {
+		if !pages[i].CreatedAt.Equal(pages[j].CreatedAt) {
+			return pages[i].CreatedAt.Before(pages[j].CreatedAt)
+		}
+		return pages[i].Link < pages[j].Link
+	})
+	return pages, nil
+}
+
+// queryRange evaluates query every step between start and end
+func (s *PrometheusPageSource) queryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) ([]prometheusSeries, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(start.Unix(), 10))
+	params.Set("end", strconv.FormatInt(end.Unix(), 10))
+	params.Set("step", strconv.FormatFloat(step.Seconds(), 'f', -1, 64))
+
+	header := http.Header{}
+	if s.Authorization != "" {
+		header.Set("Authorization", s.Authorization)
+	}
+
+	var response struct {
+		Data struct {
+			Result []prometheusSeries `json:"result"`
+		} `json:"data"`
+	}
+	if err := getJSON(ctx, nil, s.baseURL()+"/api/v1/query_range?"+params.Encode(), header, &response); err != nil {
+		return nil, err
+	}
+	return response.Data.Result, nil
+}
+
+// graphURL links to the graph of the alert series around the time it fired
+func (s *PrometheusPageSource) graphURL(selector string, start, end time.Time) string {
+	params := url.Values{}
+	params.Set("g0.expr", "ALERTS"+selector)
+	params.Set("g0.tab", "0")
+	params.Set("g0.range_input", fmt.Sprintf("%dh", int(math.Ceil(end.Sub(start).Hours()))+1))
+	params.Set("g0.end_input", end.Add(30*time.Minute).UTC().Format("2006-01-02 15:04:05"))
+	return s.baseURL() + "/graph?" + params.Encode()
+}
+
+func (s *PrometheusPageSource) baseURL() string {
+	return strings.TrimSuffix(s.URL, "/")
+}
+
+// getPrometheusFirings splits the timestamps an alert was firing at into the periods it kept firing, as their first and last timestamps.
+// An alert missing from a single evaluation stopped firing in between.
+func getPrometheusFirings(times []time.Time, step time.Duration) [][2]time.Time {
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	var firings [][2]time.Time
+	for i, t := range times {
+		if i > 0 && t.Equal(times[i-1]) {
+			continue
+		}
+		if len(firings) > 0 && t.Sub(firings[len(firings)-1][1]) <= step {
+			firings[len(firings)-1][1] = t
+			continue
+		}
+		firings = append(firings, [2]time.Time{t, t})
+	}
+	return firings
+}
+
+// getPrometheusSelector builds a selector matching exactly the given labels, but the metric name
+func getPrometheusSelector(labels map[string]string) string {
+	matchers := make([]string, 0, len(labels))
+	for name, value := range labels {
+		if name == "__name__" {
+			continue
+		}
+		matchers = append(matchers, fmt.Sprintf("%s=%q", name, value))
+	}
+	sort.Strings(matchers)
+	return "{" + strings.Join(matchers, ",") + "}"
+}
+
+// labelsToTagSet converts alert labels into "name:value" tags, so that they can be filtered like Datadog tags
+func labelsToTagSet(labels map[string]string) map[string]struct{} {
+	tags := make(map[string]struct{}, len(labels))
+	for name, value := range labels {
+		tags[name+":"+value] = struct{}{}
+	}
+	return tags
+}
@@ -0,0 +1,108 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPrometheusPageSource(t *testing.T) {
+	since := time.Date(2021, 7, 14, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2021, 7, 28, 0, 0, 0, 0, time.UTC)
+
+	// Periods alerts were firing during, evaluated every minute
+	type firing struct {
+		labels     map[string]string
+		start, end time.Time
+	}
+	disk := map[string]string{"__name__": "ALERTS", "alertname": "DiskFull on db-1", "alertstate": "firing", "team": "db", "severity": "critical"}
+	firings := []firing{
+		{disk, since.Add(time.Hour), since.Add(90 * time.Minute)},
+		// Fires again after a single missed evaluation
+		{disk, since.Add(92 * time.Minute), since.Add(95 * time.Minute)},
+		// Keeps firing across the two range queries the fortnight is split into
+		{disk, since.Add(7*24*time.Hour + 15*time.Hour), since.Add(7*24*time.Hour + 17*time.Hour)},
+		// Started before the report
+		{map[string]string{"__name__": "ALERTS", "alertname": "Old", "alertstate": "firing", "team": "db"}, since.Add(-2 * time.Hour), since.Add(time.Hour)},
+		{map[string]string{"__name__": "ALERTS", "alertname": "OtherTeam", "alertstate": "firing", "team": "web"}, since.Add(time.Hour), since.Add(2 * time.Hour)},
+	}
+
+	queries := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query_range" || r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		query := r.URL.Query()
+		if want := `ALERTS{alertstate="firing",team=~"db|web"}`; query.Get("query") != want {
+			t.Errorf("got query %q, want %q", query.Get("query"), want)
+		}
+		start, _ := strconv.ParseInt(query.Get("start"), 10, 64)
+		end, _ := strconv.ParseInt(query.Get("end"), 10, 64)
+		step, _ := strconv.ParseInt(query.Get("step"), 10, 64)
+		if points := (end-start)/step + 1; points > prometheusMaxPoints {
+			t.Errorf("got a query for %d points, want at most %d", points, prometheusMaxPoints)
+		}
+		queries++
+
+		var result []prometheusSeries
+		for _, f := range firings {
+			series := prometheusSeries{Metric: f.labels}
+			for ts := start; ts <= end; ts += step {
+				if at := time.Unix(ts, 0); !at.Before(f.start) && !at.After(f.end) {
+					series.Values = append(series.Values, [2]interface{}{float64(ts), "1"})
+				}
+			}
+			if len(series.Values) > 0 {
+				result = append(result, series)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"data":   map[string]interface{}{"resultType": "matrix", "result": result},
+		})
+	}))
+	defer server.Close()
+
+	source := &PrometheusPageSource{
+		URL:           server.URL,
+		Matchers:      []string{`team=~"db|web"`},
+		TagFilters:    []string{"team:db"},
+		Replace:       []string{"/ on db-.*//"},
+		Authorization: "Bearer token",
+	}
+	pages, err := source.FetchPages(context.Background(), since, until)
+	if err != nil {
+		t.Fatalf("FetchPages: %v", err)
+	}
+	if queries != 2 {
+		t.Errorf("got %d queries, want 2", queries)
+	}
+
+	var got []time.Time
+	for _, p := range pages {
+		if p.Title != "DiskFull" || p.Urgency != "critical" {
+			t.Errorf("got page %+v", p)
+		}
+		got = append(got, p.CreatedAt)
+	}
+	want := []time.Time{since.Add(time.Hour), since.Add(92 * time.Minute), since.Add(7*24*time.Hour + 15*time.Hour)}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got pages created at %v, want %v", got, want)
+	}
+
+	link, err := url.Parse(pages[0].Link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantExpr := `ALERTS{alertname="DiskFull on db-1",alertstate="firing",severity="critical",team="db"}`
+	if link.Path != "/graph" || link.Query().Get("g0.expr") != wantExpr || link.Query().Get("g0.end_input") != "2021-07-14 02:00:00" {
+		t.Errorf("got link %s", pages[0].Link)
+	}
+}
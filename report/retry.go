@@ -0,0 +1,129 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/PagerDuty/go-pagerduty"
+)
+
+// retryPolicy describes how calls to remote APIs are retried
+type retryPolicy struct {
+	// Maximum number of attempts, including the first one
+	attempts int
+	// Delay before the first retry, doubled for every following retry
+	baseDelay time.Duration
+	// Upper bound of the delay between two attempts
+	maxDelay time.Duration
+	// Timeout of every single attempt
+	timeout time.Duration
+	// Tells whether an error is worth retrying, defaults to isRetryable
+	retryable func(err error) bool
+}
+
+// defaultRetryPolicy is used for all Datadog, PagerDuty and Confluence calls
+var defaultRetryPolicy = retryPolicy{
+	attempts:  4,
+	baseDelay: 500 * time.Millisecond,
+	maxDelay:  10 * time.Second,
+	timeout:   30 * time.Second,
+}
+
+// statusError is returned for HTTP responses with an unexpected status code
+type statusError struct {
+	statusCode int
+	body       string
+}
+
+func (e *statusError) Error() string {
+	if e.body == "" {
+		return fmt.Sprintf("unexpected status code %d", e.statusCode)
+	}
+	return fmt.Sprintf("unexpected status code %d: %s", e.statusCode, e.body)
+}
+
+// do calls fn until it succeeds, fails with an error that is not worth retrying, or runs out of attempts.
+// Every attempt is given its own timeout, and attempts are spaced out with jittered exponential backoff.
+func (p retryPolicy) do(ctx context.Context, fn func(ctx context.Context) error) error {
+	var err error
+	delay := p.baseDelay
+	for attempt := 0; attempt < p.attempts; attempt++ {
+		if attempt > 0 {
+			// Full jitter keeps concurrent workers from retrying in lockstep
+			wait := time.Duration(rand.Int63n(int64(delay)) + 1)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			delay *= 2
+			if delay > p.maxDelay {
+				delay = p.maxDelay
+			}
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, p.timeout)
+		err = fn(attemptCtx)
+		cancel()
+
+		if err == nil || ctx.Err() != nil || !p.isRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (p retryPolicy) isRetryable(err error) bool {
+	if p.retryable != nil {
+		return p.retryable(err)
+	}
+	return isRetryable(err)
+}
+
+// isRetryable tells whether an error is transient, i.e. a timeout, a network error, a rate limit or a server error
+func isRetryable(err error) bool {
+	var pdErr pagerduty.APIError
+	if errors.As(err, &pdErr) {
+		// rateLimitedClient already retried rate limited PagerDuty calls for as long as Retry-After asked
+		return pdErr.Temporary() && pdErr.StatusCode != http.StatusTooManyRequests
+	}
+
+	var sErr *statusError
+	if errors.As(err, &sErr) {
+		return sErr.statusCode == http.StatusTooManyRequests || sErr.statusCode >= http.StatusInternalServerError
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// isRetryableBeforeSent tells whether a request that isn't idempotent can safely be sent again, i.e. it was rate limited or never reached the server.
+// Timeouts and server errors are not retried, as the server may have acted on the request already.
+func isRetryableBeforeSent(err error) bool {
+	var sErr *statusError
+	if errors.As(err, &sErr) {
+		return sErr.statusCode == http.StatusTooManyRequests
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Op == "dial"
+	}
+
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// isIdempotent tells whether sending a request with the given method twice has the same effect as sending it once
+func isIdempotent(method string) bool {
+	return method != http.MethodPost && method != http.MethodPatch
+}
@@ -3,9 +3,12 @@ package report
 import (
 	"context"
 	"fmt"
+	"io"
 	nethttp "net/http"
+	"net/url"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,13 +16,55 @@ import (
 	datadogV2 "github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
 )
 
+const (
+	// defaultIncidentPageSize is the number of incidents requested per search page
+	defaultIncidentPageSize = 50
+	// defaultMaxIncidents caps the total number of incidents fetched for a single report
+	defaultMaxIncidents = 1000
+	// defaultDatadogSite is the Datadog site used when none is configured, i.e. US1
+	defaultDatadogSite = "datadoghq.com"
+)
+
+// datadogSites maps the known Datadog sites to the URL of their web application
+var datadogSites = map[string]string{
+	"datadoghq.com":     "https://app.datadoghq.com",
+	"us3.datadoghq.com": "https://us3.datadoghq.com",
+	"us5.datadoghq.com": "https://us5.datadoghq.com",
+	"datadoghq.eu":      "https://app.datadoghq.eu",
+	"ap1.datadoghq.com": "https://ap1.datadoghq.com",
+	"ddog-gov.com":      "https://app.ddog-gov.com",
+}
+
+// getDatadogAppURL validates the Datadog site and returns the URL of its web application
+func getDatadogAppURL(site string) (string, error) {
+	if site == "" {
+		site = defaultDatadogSite
+	}
+	appURL, ok := datadogSites[site]
+	if !ok {
+		known := make([]string, 0, len(datadogSites))
+		for s := range datadogSites {
+			known = append(known, s)
+		}
+		sort.Strings(known)
+		return "", fmt.Errorf("unknown Datadog site %q, expected one of: %s", site, strings.Join(known, ", "))
+	}
+	return appURL, nil
+}
+
 type searchRequest struct {
 	createdAfter  *int64
 	createdBefore *int64
 	tags          []string
+	pageSize      int64
+	pageOffset    int64
 }
 
+// searchIncidents requests a single page of incident search results.
+// The SDK doesn't expose the page[size] and page[offset] parameters of the search endpoint, so the request is built with its client directly.
 func searchIncidents(ctx context.Context, client *datadog.APIClient, r *searchRequest) (datadogV2.IncidentSearchResponse, *nethttp.Response, error) {
+	var resp datadogV2.IncidentSearchResponse
+
 	var queryOpts []string
 	if r.createdBefore != nil {
 		queryOpts = append(queryOpts, fmt.Sprintf("created_before:%d", *r.createdBefore))
@@ -28,36 +73,93 @@ func searchIncidents(ctx context.Context, client *datadog.APIClient, r *searchRe
 		queryOpts = append(queryOpts, fmt.Sprintf("created_after:%d", *r.createdAfter))
 	}
 	queryOpts = append(queryOpts, r.tags...)
-	query := strings.Join(queryOpts, " AND ")
 
-	queryParams := datadogV2.NewSearchIncidentsOptionalParameters().WithSort(datadogV2.INCIDENTSEARCHSORTORDER_CREATED_ASCENDING)
+	basePath, err := client.Cfg.ServerURLWithContext(ctx, "v2.IncidentsApi.SearchIncidents")
+	if err != nil {
+		return resp, nil, err
+	}
+
+	queryParams := url.Values{}
+	queryParams.Set("query", strings.Join(queryOpts, " AND "))
+	queryParams.Set("sort", string(datadogV2.INCIDENTSEARCHSORTORDER_CREATED_ASCENDING))
+	queryParams.Set("page[size]", strconv.FormatInt(r.pageSize, 10))
+	queryParams.Set("page[offset]", strconv.FormatInt(r.pageOffset, 10))
 
-	api := datadogV2.NewIncidentsApi(client)
-	return api.SearchIncidents(ctx, query, *queryParams)
+	headerParams := map[string]string{"Accept": "application/json"}
+	datadog.SetAuthKeys(
+		ctx,
+		&headerParams,
+		[2]string{"apiKeyAuth", "DD-API-KEY"},
+		[2]string{"appKeyAuth", "DD-APPLICATION-KEY"},
+	)
+
+	req, err := client.PrepareRequest(ctx, basePath+"/api/v2/incidents/search", nethttp.MethodGet, nil, headerParams, queryParams, url.Values{}, nil)
+	if err != nil {
+		return resp, nil, err
+	}
+	httpResp, err := client.CallAPI(req)
+	if err != nil || httpResp == nil {
+		return resp, httpResp, err
+	}
+	body, err := io.ReadAll(httpResp.Body)
+	httpResp.Body.Close()
+	if err != nil {
+		return resp, httpResp, err
+	}
+
+	if httpResp.StatusCode >= 300 {
+		return resp, httpResp, datadog.GenericOpenAPIError{ErrorBody: body, ErrorMessage: httpResp.Status}
+	}
+	if err := client.Decode(&resp, body, httpResp.Header.Get("Content-Type")); err != nil {
+		return resp, httpResp, datadog.GenericOpenAPIError{ErrorBody: body, ErrorMessage: err.Error()}
+	}
+	return resp, httpResp, nil
+}
+
+// DatadogIncidentSource fetches incidents from Datadog Incident Management.
+// It is the default IncidentSource.
+type DatadogIncidentSource struct {
+	// Name of Datadog teams
+	Teams []string
+	// Datadog API key
+	ApiKey string
+	// Datadog application key
+	AppKey string
+	// Datadog site, e.g. "datadoghq.eu", defaults to "datadoghq.com"
+	Site string
+	// Number of incidents to request per search page, defaults to 50
+	PageSize int
+	// Maximum number of incidents to fetch, defaults to 1000
+	MaxIncidents int
+	// Fields to tag incidents with on top of their services and teams, e.g. the fields a CorrelationMapping maps tags to
+	TagFields []string
 }
 
-type incident struct {
-	id                     string
-	title                  string
-	link                   string
-	sev                    string
-	commander              string
-	commanderEmail         string
-	rootCause              string
-	summary                string
-	customerImpactScope    string
-	customerImpactDuration time.Duration
-	createdAt              time.Time
-	resolvedAt             time.Time
-	pages                  []*page
+// FetchIncidents implements IncidentSource
+func (s *DatadogIncidentSource) FetchIncidents(ctx context.Context, since, until time.Time) ([]*Incident, error) {
+	return fetchIncidents(ctx, s.Teams, s.ApiKey, s.AppKey, s.Site, since, until, s.PageSize, s.MaxIncidents, s.TagFields)
 }
 
-func fetchIncidents(teams []string, ddApiKey, ddAppKey string, since, until time.Time) ([]*incident, error) {
-	ctx := getDatadogAPIContext(ddApiKey, ddAppKey)
+func fetchIncidents(ctx context.Context, teams []string, ddApiKey, ddAppKey, ddSite string, since, until time.Time, pageSize, maxIncidents int, tagFields []string) ([]*Incident, error) {
+	if ddSite == "" {
+		ddSite = defaultDatadogSite
+	}
+	appURL, err := getDatadogAppURL(ddSite)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = getDatadogAPIContext(ctx, ddApiKey, ddAppKey, ddSite)
 	configuration := datadog.NewConfiguration()
-	configuration.SetUnstableOperationEnabled("v2.SearchIncidents", true)
 	apiClient := datadog.NewAPIClient(configuration)
 
+	if pageSize <= 0 {
+		pageSize = defaultIncidentPageSize
+	}
+	if maxIncidents <= 0 {
+		maxIncidents = defaultMaxIncidents
+	}
+
 	createdAfter := since.UTC().Unix()
 	createdBefore := until.UTC().Unix()
 	req := &searchRequest{
@@ -66,24 +168,68 @@ func fetchIncidents(teams []string, ddApiKey, ddAppKey string, since, until time
 		tags: []string{
 			getTeamFilter(teams),
 		},
+		pageSize: int64(pageSize),
 	}
 
-	resp, r, err := searchIncidents(ctx, apiClient, req)
+	var incidents []*Incident
+	var quality dataQuality
+	// Follow the page offsets until a short page signals the end of the results
+	for {
+		var resp datadogV2.IncidentSearchResponse
+		var r *nethttp.Response
+		err := defaultRetryPolicy.do(ctx, func(ctx context.Context) (err error) {
+			resp, r, err = searchIncidents(ctx, apiClient, req)
+			if err != nil && r != nil {
+				return &statusError{statusCode: r.StatusCode, body: err.Error()}
+			}
+			return err
+		})
 
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", r)
-		return nil, fmt.Errorf("Error when searching for incidents: %w", err)
+		if err != nil && len(incidents) > 0 && ctx.Err() == nil {
+			// Keep the incidents of the previous pages rather than throwing the whole report away
+			quality.add("Datadog incident search", "", "could not fetch incidents past the first %d, the report may be incomplete: %v", len(incidents), err)
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Full HTTP response: %v\n", r)
+			return nil, fmt.Errorf("Error when searching for incidents: %w", err)
+		}
+
+		if resp.Data.Attributes == nil || resp.Data.Attributes.Incidents == nil {
+			break
+		}
+
+		results := resp.Data.Attributes.Incidents
+		incidents = append(incidents, parseIncidents(resp, appURL, tagFields, &quality)...)
+
+		if len(incidents) >= maxIncidents {
+			if len(results) == pageSize {
+				fmt.Fprintf(os.Stderr, "WARN: reached the maximum of %d incidents, the report may be incomplete\n", maxIncidents)
+			}
+			incidents = incidents[:maxIncidents]
+			break
+		}
+		if len(results) < pageSize {
+			break
+		}
+		req.pageOffset += int64(len(results))
 	}
 
-	if resp.Data.Attributes == nil || resp.Data.Attributes.Incidents == nil {
-		return nil, nil
+	byCreatedAt := func(i, j int) bool {
+		return incidents[i].CreatedAt.Before(incidents[j].CreatedAt)
 	}
+	sort.Slice(incidents, byCreatedAt)
+	return incidents, quality.err()
+}
 
+// parseIncidents converts a single page of search results into incidents, linking them to the given Datadog web application and tagging them with tagFields.
+// Data that could not be found is recorded in quality.
+func parseIncidents(resp datadogV2.IncidentSearchResponse, appURL string, tagFields []string, quality *dataQuality) []*Incident {
 	// The raw API response actually contains the incident commander embedded in the incidents, but the SDK doesn't expose it, as this is technically not JSON:API compliant. The SDK only exposes an ID in the relationships.
 	// Instead we extract the incident commander data from the facets and use the commander UUID provided to map back to the full commander data
 	commanders := getIncidentCommanderMap(resp)
 
-	var incidents []*incident
+	var incidents []*Incident
 	for _, i := range resp.Data.Attributes.Incidents {
 		data := i.Data
 		if data.Type != "incidents" {
@@ -91,47 +237,111 @@ func fetchIncidents(teams []string, ddApiKey, ddAppKey string, since, until time
 		}
 		id := data.Attributes.GetPublicId()
 
-		var commander datadogV2.IncidentSearchResponseUserFacetData
-		if commanderData := data.Relationships.CommanderUser.Data.Get(); commanderData != nil {
-			commanderId := commanderData.Id
-			commander = commanders[commanderId]
-		}
-
-		incident := &incident{
-			id:                     fmt.Sprintf("#incident-%d", id),
-			title:                  data.Attributes.Title,
-			link:                   fmt.Sprintf("https://app.datadoghq.com/incidents/%d", id),
-			commander:              *commander.Name,
-			commanderEmail:         *commander.Email,
-			sev:                    data.Attributes.GetFields()["severity"].IncidentFieldAttributesSingleValue.GetValue(),
-			rootCause:              data.Attributes.GetFields()["root_cause"].IncidentFieldAttributesSingleValue.GetValue(),
-			summary:                data.Attributes.GetFields()["summary"].IncidentFieldAttributesSingleValue.GetValue(),
-			customerImpactScope:    *data.Attributes.CustomerImpactScope.Get(),
-			customerImpactDuration: time.Duration(*data.Attributes.CustomerImpactDuration * int64(time.Second)),
-			createdAt:              *data.Attributes.Created,
+		incident := &Incident{
+			ID:                     fmt.Sprintf("#incident-%d", id),
+			Title:                  data.Attributes.Title,
+			Link:                   fmt.Sprintf("%s/incidents/%d", appURL, id),
+			Severity:               data.Attributes.GetFields()["severity"].IncidentFieldAttributesSingleValue.GetValue(),
+			RootCause:              data.Attributes.GetFields()["root_cause"].IncidentFieldAttributesSingleValue.GetValue(),
+			Summary:                data.Attributes.GetFields()["summary"].IncidentFieldAttributesSingleValue.GetValue(),
+			CustomerImpactScope:    data.Attributes.GetCustomerImpactScope(),
+			CustomerImpactDuration: time.Duration(data.Attributes.GetCustomerImpactDuration()) * time.Second,
+			CreatedAt:              data.Attributes.GetCreated(),
+		}
+
+		if data.Relationships != nil && data.Relationships.CommanderUser != nil {
+			if commanderData := data.Relationships.CommanderUser.Data.Get(); commanderData != nil {
+				if commander, ok := commanders[commanderData.Id]; ok {
+					incident.Commander = commander.GetName()
+					incident.CommanderEmail = commander.GetEmail()
+				} else {
+					quality.add(incident.ID, incident.Link, "could not find incident commander %s", commanderData.Id)
+				}
+			}
 		}
 		if data.Attributes.Resolved.IsSet() && data.Attributes.Resolved.Get() != nil {
-			incident.resolvedAt = *data.Attributes.Resolved.Get()
+			incident.ResolvedAt = *data.Attributes.Resolved.Get()
 		}
+		incident.PageIDs, incident.AlertIDs, incident.MonitorIDs = getIncidentSourceIds(data.Attributes)
+		incident.Services, incident.Tags = getIncidentServicesAndTags(data.Attributes, tagFields)
 
 		incidents = append(incidents, incident)
 
 	}
-	byCreatedAt := func(i, j int) bool {
-		return incidents[i].createdAt.Before(incidents[j].createdAt)
+	return incidents
+}
+
+// getIncidentSourceIds finds the PagerDuty incidents and alerts and the Datadog monitors an incident was declared from,
+// as linked in its title or fields
+func getIncidentSourceIds(attributes *datadogV2.IncidentResponseAttributes) (pageIDs, alertIDs, monitorIDs []string) {
+	names, values := getIncidentFieldValues(attributes)
+	texts := []string{attributes.Title}
+	for _, name := range names {
+		texts = append(texts, values[name]...)
 	}
-	sort.Slice(incidents, byCreatedAt)
-	return incidents, nil
+
+	for _, text := range texts {
+		for _, m := range pagerdutyLinkRegex.FindAllStringSubmatch(text, -1) {
+			if m[1] == "alerts" {
+				alertIDs = appendUnique(alertIDs, m[2])
+			} else {
+				pageIDs = appendUnique(pageIDs, m[2])
+			}
+		}
+		for _, m := range datadogMonitorLinkRegex.FindAllStringSubmatch(text, -1) {
+			monitorIDs = appendUnique(monitorIDs, m[1])
+		}
+	}
+	return pageIDs, alertIDs, monitorIDs
+}
+
+// getIncidentServicesAndTags returns the services and teams impacted by an incident, and those along with tagFields as "name:value" tags.
+// Other fields, such as the severity or the state, say nothing about where pages come from and aren't tagged.
+// Monitors tag services and teams in the singular, so those are tagged in both forms.
+func getIncidentServicesAndTags(attributes *datadogV2.IncidentResponseAttributes, tagFields []string) (services, tags []string) {
+	names, values := getIncidentFieldValues(attributes)
+	for _, name := range names {
+		isService := name == "services" || name == "teams"
+		if !isService && !contains(tagFields, name) {
+			continue
+		}
+		for _, value := range values[name] {
+			if value == "" {
+				continue
+			}
+			tags = appendUnique(tags, name+":"+value)
+			if isService {
+				services = appendUnique(services, value)
+				tags = appendUnique(tags, strings.TrimSuffix(name, "s")+":"+value)
+			}
+		}
+	}
+	return services, tags
 }
 
-func getDatadogAPIContext(ddApiKey, ddAppKey string) context.Context {
-	ctx := context.Background()
+// getIncidentFieldValues returns the names of the fields of an incident in alphabetical order, and their values
+func getIncidentFieldValues(attributes *datadogV2.IncidentResponseAttributes) ([]string, map[string][]string) {
+	fields := attributes.GetFields()
+	names := make([]string, 0, len(fields))
+	values := make(map[string][]string, len(fields))
+	for name, field := range fields {
+		names = append(names, name)
+		if field.IncidentFieldAttributesSingleValue != nil {
+			values[name] = append(values[name], field.IncidentFieldAttributesSingleValue.GetValue())
+		}
+		if field.IncidentFieldAttributesMultipleValue != nil {
+			values[name] = append(values[name], field.IncidentFieldAttributesMultipleValue.GetValue()...)
+		}
+	}
+	sort.Strings(names)
+	return names, values
+}
 
-	// always load incidents from US1
+func getDatadogAPIContext(ctx context.Context, ddApiKey, ddAppKey, ddSite string) context.Context {
 	ctx = context.WithValue(
 		ctx,
 		datadog.ContextServerVariables,
-		map[string]string{"site": "datadoghq.com"},
+		map[string]string{"site": ddSite},
 	)
 
 	keys := make(map[string]datadog.APIKey)
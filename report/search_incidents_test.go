@@ -0,0 +1,246 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	datadog "github.com/DataDog/datadog-api-client-go/v2/api/datadog"
+	datadogV2 "github.com/DataDog/datadog-api-client-go/v2/api/datadogV2"
+)
+
+// redirectTransport sends every request to a local test server instead of the requested host
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestSearchIncidentsPagination(t *testing.T) {
+	var got url.Values
+	var gotKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/incidents/search" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		got = r.URL.Query()
+		gotKey = r.Header.Get("DD-API-KEY")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{"type":"incidents_search_results","attributes":{"facets":{},"incidents":[],"total":0}}}`))
+	}))
+	defer server.Close()
+
+	target, _ := url.Parse(server.URL)
+	configuration := datadog.NewConfiguration()
+	configuration.HTTPClient = &http.Client{Transport: redirectTransport{target: target}}
+	client := datadog.NewAPIClient(configuration)
+
+	after, before := int64(100), int64(200)
+	ctx := getDatadogAPIContext(context.Background(), "api-key", "app-key", defaultDatadogSite)
+	resp, _, err := searchIncidents(ctx, client, &searchRequest{
+		createdAfter:  &after,
+		createdBefore: &before,
+		tags:          []string{"teams:my-team"},
+		pageSize:      50,
+		pageOffset:    100,
+	})
+	if err != nil {
+		t.Fatalf("searchIncidents: %v", err)
+	}
+	if resp.Data.Attributes == nil {
+		t.Fatalf("response not decoded")
+	}
+
+	want := map[string]string{
+		"query":        "created_before:200 AND created_after:100 AND teams:my-team",
+		"sort":         "created",
+		"page[size]":   "50",
+		"page[offset]": "100",
+	}
+	for param, value := range want {
+		if got.Get(param) != value {
+			t.Errorf("%s = %q, want %q", param, got.Get(param), value)
+		}
+	}
+	if gotKey != "api-key" {
+		t.Errorf("DD-API-KEY = %q, want %q", gotKey, "api-key")
+	}
+}
+
+func TestSearchIncidentsError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"errors":["Forbidden"]}`, http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	target, _ := url.Parse(server.URL)
+	configuration := datadog.NewConfiguration()
+	configuration.HTTPClient = &http.Client{Transport: redirectTransport{target: target}}
+	client := datadog.NewAPIClient(configuration)
+
+	_, r, err := searchIncidents(context.Background(), client, &searchRequest{pageSize: 50})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if r == nil || r.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected the 403 response, got %v", r)
+	}
+}
+
+// readIncidentSearch decodes a recorded incident search response
+func readIncidentSearch(t *testing.T) datadogV2.IncidentSearchResponse {
+	t.Helper()
+	data, err := os.ReadFile("testdata/datadog_incident_search.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp datadogV2.IncidentSearchResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestParseIncidents(t *testing.T) {
+	var quality dataQuality
+	incidents := parseIncidents(readIncidentSearch(t), "https://app.datadoghq.com", nil, &quality)
+	if len(incidents) != 2 {
+		t.Fatalf("got %d incidents, want 2", len(incidents))
+	}
+
+	resolved := incidents[0]
+	want := Incident{
+		ID:                     "#incident-1",
+		Title:                  "Checkout is down",
+		Link:                   "https://app.datadoghq.com/incidents/1",
+		Severity:               "SEV-2",
+		Commander:              "Ina Commander",
+		CommanderEmail:         "ic@example.com",
+		RootCause:              "Bad deploy",
+		CustomerImpactScope:    "All checkouts failed",
+		CustomerImpactDuration: 90 * time.Second,
+		CreatedAt:              time.Date(2021, 7, 20, 10, 0, 0, 0, time.UTC),
+		ResolvedAt:             time.Date(2021, 7, 20, 11, 0, 0, 0, time.UTC),
+	}
+	if resolved.ID != want.ID || resolved.Title != want.Title || resolved.Link != want.Link || resolved.Severity != want.Severity ||
+		resolved.Commander != want.Commander || resolved.CommanderEmail != want.CommanderEmail || resolved.RootCause != want.RootCause ||
+		resolved.CustomerImpactScope != want.CustomerImpactScope || resolved.CustomerImpactDuration != want.CustomerImpactDuration ||
+		!resolved.CreatedAt.Equal(want.CreatedAt) || !resolved.ResolvedAt.Equal(want.ResolvedAt) {
+		t.Errorf("got %+v, want %+v", *resolved, want)
+	}
+
+	// The second incident is still open and its commander is missing from the facets
+	open := incidents[1]
+	if !open.ResolvedAt.IsZero() {
+		t.Errorf("open incident resolved at %v", open.ResolvedAt)
+	}
+	if open.Commander != "" || open.CustomerImpactScope != "" {
+		t.Errorf("got commander %q and impact %q, want none", open.Commander, open.CustomerImpactScope)
+	}
+
+	var partialErr *PartialDataError
+	if err := quality.err(); !errors.As(err, &partialErr) {
+		t.Fatalf("got %v, want a *PartialDataError", err)
+	}
+	if len(partialErr.Issues) != 1 || partialErr.Issues[0].Subject != "#incident-2" {
+		t.Errorf("got issues %v, want one about #incident-2", partialErr.Issues)
+	}
+}
+
+// incidentAttributes decodes the attributes of a Datadog incident
+func incidentAttributes(t *testing.T, data string) *datadogV2.IncidentResponseAttributes {
+	t.Helper()
+	var attributes datadogV2.IncidentResponseAttributes
+	if err := json.Unmarshal([]byte(data), &attributes); err != nil {
+		t.Fatal(err)
+	}
+	return &attributes
+}
+
+func TestGetIncidentSourceIds(t *testing.T) {
+	tests := []struct {
+		name           string
+		attributes     string
+		wantPageIDs    []string
+		wantAlertIDs   []string
+		wantMonitorIDs []string
+	}{
+		{
+			name:       "no links",
+			attributes: `{"title": "Checkout is down", "fields": {"summary": {"type": "textbox", "value": "Rolled back"}}}`,
+		},
+		{
+			name:           "links in fields",
+			attributes:     `{"title": "Checkout is down", "fields": {"summary": {"type": "textbox", "value": "Declared from https://acme.pagerduty.com/incidents/Q1ABC23, see https://app.datadoghq.com/monitors/4242"}}}`,
+			wantPageIDs:    []string{"Q1ABC23"},
+			wantMonitorIDs: []string{"4242"},
+		},
+		{
+			name:         "alert link in the title",
+			attributes:   `{"title": "Checkout is down https://acme.pagerduty.com/alerts/A7XYZ", "fields": {}}`,
+			wantAlertIDs: []string{"A7XYZ"},
+		},
+		{
+			name: "links in multiple value fields, once each",
+			attributes: `{"title": "Checkout is down", "fields": {
+				"pages": {"type": "autocomplete", "value": ["https://acme.pagerduty.com/incidents/Q1", "https://acme.pagerduty.com/incidents/Q2"]},
+				"summary": {"type": "textbox", "value": "https://acme.pagerduty.com/incidents/Q1 https://app.datadoghq.com/monitors/1 https://app.datadoghq.com/monitors/1"}
+			}}`,
+			wantPageIDs:    []string{"Q1", "Q2"},
+			wantMonitorIDs: []string{"1"},
+		},
+	}
+	for _, tt := range tests {
+		pageIDs, alertIDs, monitorIDs := getIncidentSourceIds(incidentAttributes(t, tt.attributes))
+		if !reflect.DeepEqual(pageIDs, tt.wantPageIDs) || !reflect.DeepEqual(alertIDs, tt.wantAlertIDs) || !reflect.DeepEqual(monitorIDs, tt.wantMonitorIDs) {
+			t.Errorf("%s: getIncidentSourceIds() = %v, %v, %v, want %v, %v, %v", tt.name, pageIDs, alertIDs, monitorIDs, tt.wantPageIDs, tt.wantAlertIDs, tt.wantMonitorIDs)
+		}
+	}
+}
+
+func TestGetIncidentServicesAndTags(t *testing.T) {
+	attributes := incidentAttributes(t, `{"title": "Checkout is down", "fields": {
+		"services": {"type": "autocomplete", "value": ["checkout", "payments"]},
+		"teams": {"type": "autocomplete", "value": ["payments"]},
+		"severity": {"type": "dropdown", "value": "SEV-2"},
+		"state": {"type": "dropdown", "value": "resolved"},
+		"environment": {"type": "dropdown", "value": "production"}
+	}}`)
+
+	tests := []struct {
+		name         string
+		tagFields    []string
+		wantServices []string
+		wantTags     []string
+	}{
+		{
+			name:         "services and teams only",
+			wantServices: []string{"checkout", "payments"},
+			wantTags:     []string{"services:checkout", "service:checkout", "services:payments", "service:payments", "teams:payments", "team:payments"},
+		},
+		{
+			name:         "mapped fields",
+			tagFields:    []string{"environment"},
+			wantServices: []string{"checkout", "payments"},
+			wantTags:     []string{"environment:production", "services:checkout", "service:checkout", "services:payments", "service:payments", "teams:payments", "team:payments"},
+		},
+	}
+	for _, tt := range tests {
+		services, tags := getIncidentServicesAndTags(attributes, tt.tagFields)
+		if !reflect.DeepEqual(services, tt.wantServices) || !reflect.DeepEqual(tags, tt.wantTags) {
+			t.Errorf("%s: getIncidentServicesAndTags() = %v, %v, want %v, %v", tt.name, services, tags, tt.wantServices, tt.wantTags)
+		}
+	}
+}
@@ -4,121 +4,271 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/PagerDuty/go-pagerduty"
 )
 
-type pageNote struct {
-	content   string
-	userName  string
-	userEmail string
+const (
+	// pagerdutyPageLimit is the maximum number of results PagerDuty returns per request
+	pagerdutyPageLimit = 100
+	// pagerdutyMaxOffset is the ceiling PagerDuty puts on offset+limit in classic pagination
+	pagerdutyMaxOffset = 10000
+)
+
+// PagerDutyPageSource fetches pages from PagerDuty.
+// It is the default PageSource.
+type PagerDutyPageSource struct {
+	// Name of PagerDuty teams, in lower case
+	Teams []string
+	// Only keep pages with alerts carrying one of these Datadog tags
+	TagFilters []string
+	// PagerDuty API token
+	AuthToken string
+	// Page urgency
+	Urgency string
+	// Replacement regexes to apply to page titles, in the form "/regex/replacement/"
+	Replace []string
+	// Fetch the alerts grouped under every page, for their IDs, monitors and tags to correlate pages with incidents.
+	// Alerts are always fetched when filtering by tags, BuildReport sets this when the incidents need them.
+	FetchAlerts bool
+	// Number of pages to fetch details for concurrently, defaults to 4
+	Concurrency int
+	// Optional path of a file caching PagerDuty users across runs
+	UserCachePath string
+	// How long the user cache stays valid, defaults to 24 hours
+	UserCacheTTL time.Duration
 }
 
-type page struct {
-	title       string
-	link        string
-	createdAt   time.Time
-	incidentIDs []string
-	responders  []string
-	notes       []pageNote
+// FetchPages implements PageSource
+func (s *PagerDutyPageSource) FetchPages(ctx context.Context, since, until time.Time) ([]*Page, error) {
+	return fetchPages(ctx, s.Teams, since, until, s.TagFilters, s.AuthToken, s.Urgency, s.Replace, s.FetchAlerts, s.Concurrency, s.UserCachePath, s.UserCacheTTL)
 }
 
-func fetchPages(pagerdutyTeams []string, since, until string, tagFilters []string, authToken string, urgency string, replace []string) ([]*page, error) {
+func fetchPages(ctx context.Context, pagerdutyTeams []string, since, until time.Time, tagFilters []string, authToken string, urgency string, replace []string, fetchAlerts bool, concurrency int, userCachePath string, userCacheTTL time.Duration) ([]*Page, error) {
 	client := pagerduty.NewClient(authToken)
+	client.HTTPClient = newRateLimitedClient(http.DefaultClient)
 
 	regexReplace, err := getRegexReplace(replace)
 	if err != nil {
 		return nil, err
 	}
 
-	teamIDs, err := getTeamIds(pagerdutyTeams, client)
+	teamIDs, err := getTeamIds(ctx, pagerdutyTeams, client)
 	if err != nil {
 		return nil, err
 	}
 
-	incResp, err := client.ListIncidentsWithContext(context.Background(), pagerduty.ListIncidentsOptions{
-		Limit:     1000,
+	incidents, err := listIncidents(ctx, client, pagerduty.ListIncidentsOptions{
 		TeamIDs:   teamIDs,
-		Since:     since,
-		Until:     until,
 		Urgencies: []string{urgency},
-	})
+	}, since, until)
 
 	if err != nil {
 		return nil, err
 	}
 
-	var pages []*page
+	users := newUserDirectory(ctx, client, userCachePath, userCacheTTL)
+	var quality dataQuality
+
+	results := make([]*Page, len(incidents))
+	// Don't bother with partial results if the run was cancelled
+	err = forEachConcurrently(len(incidents), concurrency, func(i int) error {
+		results[i] = fetchPage(ctx, client, users, &quality, incidents[i], tagFilters, fetchAlerts, regexReplace)
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := users.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write user cache %s, ignoring: %v\n", userCachePath, err)
+	}
 
-	for _, p := range incResp.Incidents {
-		matched, err := pagerdutyIncidentMatchesTags(client, p.ID, tagFilters)
+	// Drop incidents that were filtered out while keeping the original order
+	var pages []*Page
+	for _, p := range results {
+		if p != nil {
+			pages = append(pages, p)
+		}
+	}
+	// Pages are listed oldest first, keep them that way however the API sorts incidents created on the same second
+	sort.SliceStable(pages, func(i, j int) bool {
+		return pages[i].CreatedAt.Before(pages[j].CreatedAt)
+	})
+	return pages, quality.err()
+}
+
+// fetchPage enriches a single PagerDuty incident with its notes and responders, and its alerts when filtering by tags or when fetchAlerts is set.
+// It returns nil if the incident doesn't match the tag filters or its tags could not be fetched.
+// Any data that could not be fetched is recorded in quality.
+func fetchPage(ctx context.Context, client *pagerduty.Client, users *userDirectory, quality *dataQuality, p pagerduty.Incident, tagFilters []string, fetchAlerts bool, regexReplace map[*regexp.Regexp]string) *Page {
+	var alerts []pagerduty.IncidentAlert
+	var err error
+	if len(tagFilters) > 0 || fetchAlerts {
+		alerts, err = listPagerdutyAlerts(ctx, client, p.ID)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Could not fetch tags for incident %s, skipping: %v\n", p.ID, err)
-			continue
+			if len(tagFilters) > 0 {
+				quality.add(p.Title, p.HTMLURL, "could not fetch tags, page skipped: %v", err)
+				return nil
+			}
+			quality.add(p.Title, p.HTMLURL, "could not fetch alerts: %v", err)
+		}
+		if !pagerdutyAlertsMatchTags(alerts, tagFilters) {
+			return nil
+		}
+	}
+
+	var alertIDs, monitorIDs, tags []string
+	for _, a := range alerts {
+		alertIDs = append(alertIDs, a.ID)
+		monitorIDs = getMonitorIdsFromPagerdutyAlert(monitorIDs, a.Body)
+		for tag := range getTagsFromPagerdutyAlert(a) {
+			if tag != "" {
+				tags = appendUnique(tags, tag)
+			}
 		}
-		if !matched {
-			continue
+	}
+	sort.Strings(monitorIDs)
+	sort.Strings(tags)
+
+	title := replaceTitle(p.Title, regexReplace)
+	createdAt, _ := time.Parse(time.RFC3339, p.CreatedAt)
+
+	var notes []pagerduty.IncidentNote
+	err = defaultRetryPolicy.do(ctx, func(ctx context.Context) (err error) {
+		notes, err = client.ListIncidentNotesWithContext(ctx, p.ID)
+		return err
+	})
+	if err != nil {
+		quality.add(p.Title, p.HTMLURL, "could not fetch notes: %v", err)
+	}
+
+	var pageNotes []PageNote
+	for _, n := range notes {
+		note := PageNote{
+			Content: n.Content,
 		}
 
-		title := p.Title
-		for r, replace := range regexReplace {
-			title = r.ReplaceAllString(title, replace)
+		if u, err := users.get(ctx, n.User.ID); err != nil {
+			quality.add(p.Title, p.HTMLURL, "could not fetch note author %s: %v", n.User.ID, err)
+		} else {
+			note.UserName = u.Name
+			note.UserEmail = u.Email
 		}
-		createdAt, _ := time.Parse(time.RFC3339, p.CreatedAt)
+		pageNotes = append(pageNotes, note)
+	}
 
-		notes, err := client.ListIncidentNotesWithContext(context.Background(), p.ID)
+	var logEntries []pagerduty.LogEntry
+	err = defaultRetryPolicy.do(ctx, func(ctx context.Context) error {
+		logs, err := client.ListIncidentLogEntriesWithContext(ctx, p.ID, pagerduty.ListIncidentLogEntriesOptions{})
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Could not fetch notes for incident %s, skipping: %v\n", p.ID, err)
-			continue
+			return err
 		}
+		logEntries = logs.LogEntries
+		return nil
+	})
+	if err != nil {
+		quality.add(p.Title, p.HTMLURL, "could not fetch responders: %v", err)
+	}
 
-		var pageNotes []pageNote
-		for _, n := range notes {
-			note := pageNote{
-				content: n.Content,
+	var responders []string
+	for _, l := range logEntries {
+
+		for _, a := range l.Assignees {
+			if a.Type != "user_reference" {
+				continue
 			}
 
-			if u, err := client.GetUserWithContext(context.Background(), n.User.ID, pagerduty.GetUserOptions{}); err != nil {
-				fmt.Fprintf(os.Stderr, "Could not fetch user %s, ignoring: %v\n", n.User.ID, err)
-			} else {
-				note.userName = u.Name
-				note.userEmail = u.Email
+			u, err := users.get(ctx, a.ID)
+			if err != nil {
+				quality.add(p.Title, p.HTMLURL, "could not fetch responder %s: %v", a.ID, err)
+				continue
 			}
-			pageNotes = append(pageNotes, note)
+			responders = append(responders, u.Email)
 		}
+	}
 
-		logs, _ := client.ListIncidentLogEntriesWithContext(context.Background(), p.ID, pagerduty.ListIncidentLogEntriesOptions{})
+	return &Page{
+		Title:      title,
+		Link:       p.HTMLURL,
+		ID:         p.ID,
+		AlertIDs:   alertIDs,
+		MonitorIDs: monitorIDs,
+		Service:    p.Service.Summary,
+		Tags:       tags,
+		CreatedAt:  createdAt,
+		Urgency:    p.Urgency,
+		Responders: responders,
+		Notes:      pageNotes,
+	}
+}
 
-		var responders []string
-		for _, l := range logs.LogEntries {
+// listIncidents fetches all PagerDuty incidents created between since and until, oldest first.
+// PagerDuty doesn't paginate past pagerdutyMaxOffset results, so once the ceiling is reached, the rest of the window is listed again from the last incident fetched.
+func listIncidents(ctx context.Context, client *pagerduty.Client, opts pagerduty.ListIncidentsOptions, since, until time.Time) ([]pagerduty.Incident, error) {
+	opts.Until = until.UTC().Format(time.RFC3339)
+	opts.Limit = pagerdutyPageLimit
+	opts.SortBy = "created_at:asc"
 
-			for _, a := range l.Assignees {
-				if a.Type != "user_reference" {
-					continue
-				}
+	var incidents []pagerduty.Incident
+	seen := make(map[string]struct{})
+	for {
+		opts.Since = since.UTC().Format(time.RFC3339)
+		listed, more, err := listIncidentsUpToCeiling(ctx, client, opts)
+		if err != nil {
+			return nil, err
+		}
 
-				u, err := client.GetUserWithContext(context.Background(), a.ID, pagerduty.GetUserOptions{})
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Could not fetch user %s, ignoring: %v\n", a.ID, err)
-					continue
-				}
-				responders = append(responders, u.Email)
+		// Incidents created on the second the window restarts from are listed twice
+		for _, i := range listed {
+			if _, ok := seen[i.ID]; ok {
+				continue
 			}
+			seen[i.ID] = struct{}{}
+			incidents = append(incidents, i)
 		}
+		if !more {
+			return incidents, nil
+		}
+
+		last, err := time.Parse(time.RFC3339, listed[len(listed)-1].CreatedAt)
+		if err != nil || !last.After(since) {
+			fmt.Fprintf(os.Stderr, "WARN: too many PagerDuty incidents created at %s, the report may be incomplete\n", opts.Since)
+			return incidents, nil
+		}
+		since = last
+	}
+}
 
-		pages = append(pages, &page{
-			title:      p.Title,
-			link:       p.HTMLURL,
-			createdAt:  createdAt,
-			responders: responders,
-			notes:      pageNotes,
+// listIncidentsUpToCeiling paginates through the incidents matching opts until PagerDuty's offset ceiling, and tells whether more are left
+func listIncidentsUpToCeiling(ctx context.Context, client *pagerduty.Client, opts pagerduty.ListIncidentsOptions) ([]pagerduty.Incident, bool, error) {
+	var incidents []pagerduty.Incident
+	for {
+		opts.Offset = uint(len(incidents))
+		var response *pagerduty.ListIncidentsResponse
+		err := defaultRetryPolicy.do(ctx, func(ctx context.Context) (err error) {
+			response, err = client.ListIncidentsWithContext(ctx, opts)
+			return err
 		})
+		if err != nil {
+			return nil, false, err
+		}
+
+		incidents = append(incidents, response.Incidents...)
+		if !response.More || len(response.Incidents) == 0 {
+			return incidents, false, nil
+		}
+		if uint(len(incidents))+opts.Limit > pagerdutyMaxOffset {
+			return incidents, true, nil
+		}
 	}
-	return pages, nil
 }
 
 func getRegexReplace(replace []string) (map[*regexp.Regexp]string, error) {
@@ -140,36 +290,50 @@ func getRegexReplace(replace []string) (map[*regexp.Regexp]string, error) {
 	return regexReplace, nil
 }
 
-func pagerdutyIncidentMatchesTags(client *pagerduty.Client, incidentId string, tagFilters []string) (bool, error) {
-	if tagFilters == nil || len(tagFilters) == 0 {
-		return true, nil
+// listPagerdutyAlerts fetches the alerts grouped under a PagerDuty incident
+func listPagerdutyAlerts(ctx context.Context, client *pagerduty.Client, incidentId string) ([]pagerduty.IncidentAlert, error) {
+	var alertsResp *pagerduty.ListAlertsResponse
+	err := defaultRetryPolicy.do(ctx, func(ctx context.Context) (err error) {
+		alertsResp, err = client.ListIncidentAlertsWithContext(ctx, incidentId, pagerduty.ListIncidentAlertsOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	return alertsResp.Alerts, nil
+}
 
-	alertsResp, err := client.ListIncidentAlerts(incidentId)
-	if err != nil {
-		return false, err
+// pagerdutyAlertsMatchTags tells whether any of the alerts carries one of the tag filters
+func pagerdutyAlertsMatchTags(alerts []pagerduty.IncidentAlert, tagFilters []string) bool {
+	if len(tagFilters) == 0 {
+		return true
 	}
 
-	for _, a := range alertsResp.Alerts {
-		alertTags := getTagsFromPagerdutyAlert(a)
-		if alertTags == nil {
-			continue
+	for _, a := range alerts {
+		if matchesTagFilters(getTagsFromPagerdutyAlert(a), tagFilters) {
+			return true
 		}
+	}
 
-		found := false
-		for _, tagFilter := range tagFilters {
-			if _, ok := alertTags[tagFilter]; ok {
-				found = true
-				break
-			}
-		}
+	return false
+}
 
-		if found {
-			return true, nil
+// matchesTagFilters tells whether any of the tag filters is found in tags
+func matchesTagFilters(tags map[string]struct{}, tagFilters []string) bool {
+	for _, tagFilter := range tagFilters {
+		if _, ok := tags[tagFilter]; ok {
+			return true
 		}
 	}
+	return false
+}
 
-	return false, nil
+// replaceTitle applies the replacement regexes to a page title
+func replaceTitle(title string, regexReplace map[*regexp.Regexp]string) string {
+	for r, replace := range regexReplace {
+		title = r.ReplaceAllString(title, replace)
+	}
+	return title
 }
 
 func getTagsFromPagerdutyAlert(alert pagerduty.IncidentAlert) map[string]struct{} {
@@ -202,12 +366,40 @@ func getTagsFromPagerdutyAlert(alert pagerduty.IncidentAlert) map[string]struct{
 	return alertTags
 }
 
+// getMonitorIdsFromPagerdutyAlert appends to monitorIDs the Datadog monitors found in the body of an alert,
+// either as a "monitor_id" detail or as a link to the monitor
+func getMonitorIdsFromPagerdutyAlert(monitorIDs []string, body interface{}) []string {
+	switch v := body.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if id, ok := value.(float64); ok && key == "monitor_id" {
+				monitorIDs = appendUnique(monitorIDs, strconv.FormatFloat(id, 'f', -1, 64))
+				continue
+			}
+			if id, ok := value.(string); ok && key == "monitor_id" {
+				monitorIDs = appendUnique(monitorIDs, id)
+				continue
+			}
+			monitorIDs = getMonitorIdsFromPagerdutyAlert(monitorIDs, value)
+		}
+	case []interface{}:
+		for _, value := range v {
+			monitorIDs = getMonitorIdsFromPagerdutyAlert(monitorIDs, value)
+		}
+	case string:
+		for _, m := range datadogMonitorLinkRegex.FindAllStringSubmatch(v, -1) {
+			monitorIDs = appendUnique(monitorIDs, m[1])
+		}
+	}
+	return monitorIDs
+}
+
 // getTeamIds searches for the pagerduty team ids given their team names
-func getTeamIds(teams []string, client *pagerduty.Client) ([]string, error) {
+func getTeamIds(ctx context.Context, teams []string, client *pagerduty.Client) ([]string, error) {
 	teamIDs := make([]string, 0, len(teams))
 	errs := make([]error, 0, len(teams))
 	for _, team := range teams {
-		teamID, err := getTeamId(team, client)
+		teamID, err := getTeamId(ctx, team, client)
 		if err == nil {
 			teamIDs = append(teamIDs, teamID)
 		} else {
@@ -227,13 +419,17 @@ func getTeamIds(teams []string, client *pagerduty.Client) ([]string, error) {
 }
 
 // getTeamId searches for the pagerduty team id given its team name
-func getTeamId(name string, client *pagerduty.Client) (string, error) {
+func getTeamId(ctx context.Context, name string, client *pagerduty.Client) (string, error) {
 	var offset uint
 	// Paginate through results until we find the team there are no more results
 	for {
-		response, err := client.ListTeams(pagerduty.ListTeamOptions{
-			Offset: offset,
-			Limit:  100, // PD only allows up to 100 results through the API
+		var response *pagerduty.ListTeamResponse
+		err := defaultRetryPolicy.do(ctx, func(ctx context.Context) (err error) {
+			response, err = client.ListTeamsWithContext(ctx, pagerduty.ListTeamOptions{
+				Offset: offset,
+				Limit:  100, // PD only allows up to 100 results through the API
+			})
+			return err
 		})
 
 		if err != nil {
@@ -0,0 +1,127 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/PagerDuty/go-pagerduty"
+)
+
+func TestReplaceTitle(t *testing.T) {
+	regexReplace, err := getRegexReplace([]string{"/service-pod-.*/service-pod/", "/ \\[staging\\]//"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"CPU high on service-pod-7f9c4", "CPU high on service-pod"},
+		{"Disk full [staging]", "Disk full"},
+		{"Unrelated", "Unrelated"},
+	}
+	for _, tt := range tests {
+		if got := replaceTitle(tt.title, regexReplace); got != tt.want {
+			t.Errorf("replaceTitle(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestGetMonitorIdsFromPagerdutyAlert(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"no body", `null`, nil},
+		{"numeric monitor id", `{"details": {"monitor_id": 4242}}`, []string{"4242"}},
+		{"string monitor id", `{"cef_details": {"details": {"monitor_id": "4242"}}}`, []string{"4242"}},
+		{"monitor links", `{"contexts": [{"type": "link", "href": "https://app.datadoghq.com/monitors/4242?from_ts=1"}, {"href": "https://app.datadoghq.com/monitors/17"}]}`, []string{"17", "4242"}},
+		{"duplicates", `{"details": {"monitor_id": 4242, "body": "See https://app.datadoghq.com/monitors/4242"}}`, []string{"4242"}},
+		{"other ids", `{"details": {"id": 4242, "event_id": "17"}}`, nil},
+	}
+	for _, tt := range tests {
+		var body interface{}
+		if err := json.Unmarshal([]byte(tt.body), &body); err != nil {
+			t.Fatal(err)
+		}
+		got := getMonitorIdsFromPagerdutyAlert(nil, body)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: getMonitorIdsFromPagerdutyAlert() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestListIncidentsPastOffsetCeiling(t *testing.T) {
+	// Three incidents a second, so that the window restarts on a second holding incidents already listed
+	since := time.Date(2021, 7, 14, 0, 0, 0, 0, time.UTC)
+	until := since.Add(24 * time.Hour)
+	var all []pagerduty.Incident
+	var createdAt []time.Time
+	for i := 0; i < pagerdutyMaxOffset+250; i++ {
+		createdAt = append(createdAt, since.Add(time.Duration(i/3)*time.Second))
+		all = append(all, pagerduty.Incident{
+			APIObject: pagerduty.APIObject{ID: fmt.Sprintf("P%05d", i)},
+			CreatedAt: createdAt[i].Format(time.RFC3339),
+		})
+	}
+
+	served := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("sort_by") != "created_at:asc" {
+			t.Errorf("got sort_by %q, want created_at:asc", query.Get("sort_by"))
+		}
+		from, _ := time.Parse(time.RFC3339, query.Get("since"))
+		to, _ := time.Parse(time.RFC3339, query.Get("until"))
+		offset, _ := strconv.Atoi(query.Get("offset"))
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		if offset+limit > pagerdutyMaxOffset {
+			http.Error(w, `{"error": {"message": "Offset must be less than 10000"}}`, http.StatusBadRequest)
+			return
+		}
+
+		matching := all[sort.Search(len(all), func(i int) bool { return !createdAt[i].Before(from) }):sort.Search(len(all), func(i int) bool { return !createdAt[i].Before(to) })]
+		end := offset + limit
+		if end > len(matching) {
+			end = len(matching)
+		}
+		served += end - offset
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(pagerduty.ListIncidentsResponse{
+			APIListObject: pagerduty.APIListObject{Limit: uint(limit), Offset: uint(offset), More: end < len(matching)},
+			Incidents:     matching[offset:end],
+		})
+	}))
+	defer server.Close()
+
+	client := pagerduty.NewClient("token", pagerduty.WithAPIEndpoint(server.URL))
+	incidents, err := listIncidents(context.Background(), client, pagerduty.ListIncidentsOptions{}, since, until)
+	if err != nil {
+		t.Fatalf("listIncidents: %v", err)
+	}
+
+	if len(incidents) != len(all) {
+		t.Fatalf("got %d incidents, want %d", len(incidents), len(all))
+	}
+	for n, i := range incidents {
+		if i.ID != all[n].ID {
+			t.Fatalf("got incident %s at %d, want %s", i.ID, n, all[n].ID)
+		}
+	}
+	// Only the incidents of the second the window restarted from are listed twice
+	if served != len(all)+1 {
+		t.Errorf("listed %d incidents, want %d", served, len(all)+1)
+	}
+}
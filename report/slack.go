@@ -0,0 +1,161 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// defaultSlackApiURL is the Slack Web API used when none is configured
+	defaultSlackApiURL = "https://slack.com/api"
+	// slackMaxBlocks is the maximum number of blocks Slack accepts in a single message
+	slackMaxBlocks = 50
+	// slackMaxHeaderLength is the maximum number of characters Slack accepts in a header block
+	slackMaxHeaderLength = 150
+)
+
+// SlackRequest describes where to post the summary of a report in Slack.
+// Either WebhookURL, or Token and Channel must be set.
+type SlackRequest struct {
+	// URL of an incoming webhook, which posts to the channel it was created for
+	WebhookURL string
+	// Bot token allowed to post to Channel
+	Token string
+	// Channel to post to with Token, e.g. "#my-team" or a channel id
+	Channel string
+	// Base URL of the Slack Web API, defaults to https://slack.com/api
+	ApiURL string
+	// Report to summarize
+	Report *Report
+	// Optional link to the full report, e.g. the Confluence page it was uploaded to
+	ReportURL string
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackBlock struct {
+	Type     string       `json:"type"`
+	Text     *slackText   `json:"text,omitempty"`
+	Elements []*slackText `json:"elements,omitempty"`
+}
+
+type slackMessage struct {
+	Channel string       `json:"channel,omitempty"`
+	Text    string       `json:"text"`
+	Blocks  []slackBlock `json:"blocks"`
+}
+
+// PostSlack posts a summary of the report to Slack: the totals, one block per incident, and a link to the full report
+func PostSlack(ctx context.Context, request SlackRequest) error {
+	_, err := PostSlackMessage(ctx, request)
+	return err
+}
+
+// PostSlackMessage is like PostSlack, and returns the permalink of the message when posting with a bot token.
+// Incoming webhooks don't tell where the message was posted, so the permalink is empty for them.
+func PostSlackMessage(ctx context.Context, request SlackRequest) (string, error) {
+	message := slackMessage{
+		Text:   request.Report.Title,
+		Blocks: slackBlocks(request.Report, request.ReportURL),
+	}
+
+	if request.WebhookURL != "" {
+		// Webhooks answer with a plain "ok" rather than JSON
+		if err := postJSON(ctx, request.WebhookURL, nil, message, nil); err != nil {
+			return "", fmt.Errorf("failed to post to Slack: %w", err)
+		}
+		return "", nil
+	}
+
+	apiURL := defaultSlackApiURL
+	if request.ApiURL != "" {
+		apiURL = strings.TrimSuffix(request.ApiURL, "/")
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+request.Token)
+	message.Channel = request.Channel
+
+	// The Web API reports errors in the response rather than with a status code
+	var response struct {
+		OK      bool   `json:"ok"`
+		Error   string `json:"error"`
+		Channel string `json:"channel"`
+		TS      string `json:"ts"`
+	}
+	if err := postJSON(ctx, apiURL+"/chat.postMessage", header, message, &response); err != nil {
+		return "", fmt.Errorf("failed to post to Slack: %w", err)
+	}
+	if !response.OK {
+		return "", fmt.Errorf("failed to post to Slack: %s", response.Error)
+	}
+
+	params := url.Values{}
+	params.Set("channel", response.Channel)
+	params.Set("message_ts", response.TS)
+	var permalink struct {
+		OK        bool   `json:"ok"`
+		Error     string `json:"error"`
+		Permalink string `json:"permalink"`
+	}
+	if err := getJSON(ctx, nil, apiURL+"/chat.getPermalink?"+params.Encode(), header, &permalink); err != nil {
+		return "", fmt.Errorf("posted to Slack, but failed to get the link to the message: %w", err)
+	}
+	if !permalink.OK {
+		return "", fmt.Errorf("posted to Slack, but failed to get the link to the message: %s", permalink.Error)
+	}
+	return permalink.Permalink, nil
+}
+
+// slackBlocks builds the Block Kit blocks summarizing the report, keeping under the number of blocks Slack accepts
+func slackBlocks(r *Report, reportURL string) []slackBlock {
+	blocks := []slackBlock{
+		{Type: "header", Text: &slackText{Type: "plain_text", Text: truncate(r.Title, slackMaxHeaderLength)}},
+		{Type: "section", Text: &slackText{Type: "mrkdwn", Text: fmt.Sprintf("Report for %s - %s: total incidents - %d, total pages - %d", r.Since, r.Until, r.TotalIncidents, r.TotalPages)}},
+	}
+
+	// Keep room for the divider, the link to the report and a note about the incidents left out
+	incidents := r.Incidents
+	if limit := slackMaxBlocks - len(blocks) - 3; len(incidents) > limit {
+		incidents = incidents[:limit]
+	}
+
+	if len(incidents) > 0 {
+		blocks = append(blocks, slackBlock{Type: "divider"})
+	}
+	for _, i := range incidents {
+		commander := i.CommanderEmail
+		if commander == "" {
+			commander = "n/a"
+		}
+		text := fmt.Sprintf("*<%s|%s | %s | %s>*\nIC: %s, pages: %d", i.Link, slackEscape(i.Severity), slackEscape(i.ID), slackEscape(i.Title), slackEscape(commander), len(i.Pages))
+		blocks = append(blocks, slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: text}})
+	}
+	if left := len(r.Incidents) - len(incidents); left > 0 {
+		blocks = append(blocks, slackBlock{Type: "context", Elements: []*slackText{{Type: "mrkdwn", Text: fmt.Sprintf("and %d more incidents", left)}}})
+	}
+
+	if reportURL != "" {
+		blocks = append(blocks, slackBlock{Type: "context", Elements: []*slackText{{Type: "mrkdwn", Text: fmt.Sprintf("<%s|Full report>", reportURL)}}})
+	}
+	return blocks
+}
+
+// truncate shortens s to at most length characters, ending it with an ellipsis if it was too long
+func truncate(s string, length int) string {
+	if utf8.RuneCountInString(s) <= length {
+		return s
+	}
+	return string([]rune(s)[:length-1]) + "…"
+}
+
+// slackEscape escapes the characters Slack gives a special meaning to in mrkdwn text
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
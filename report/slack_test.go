@@ -0,0 +1,158 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestPostSlackWebhook(t *testing.T) {
+	var message slackMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/services/T1/B1/secret" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			t.Errorf("error decoding message: %v", err)
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	permalink, err := PostSlackMessage(context.Background(), SlackRequest{
+		WebhookURL: server.URL + "/services/T1/B1/secret",
+		Report:     goldenReport(),
+		ReportURL:  "https://my-company.atlassian.net/wiki/spaces/SRE/pages/1",
+	})
+	if err != nil {
+		t.Fatalf("PostSlackMessage: %v", err)
+	}
+	if permalink != "" {
+		t.Errorf("got permalink %q for a webhook, want none", permalink)
+	}
+
+	if message.Channel != "" || message.Text != "My-Team On-Call Report 2021-07-27" {
+		t.Errorf("got channel %q and text %q", message.Channel, message.Text)
+	}
+	// Header, totals, divider, two incidents and the link to the report
+	if len(message.Blocks) != 6 {
+		t.Fatalf("got %d blocks, want 6", len(message.Blocks))
+	}
+	if want := "*<https://app.datadoghq.com/incidents/1|SEV-2 | #incident-1 | Checkout is down>*\nIC: ic@example.com, pages: 2"; message.Blocks[3].Text.Text != want {
+		t.Errorf("got incident %q, want %q", message.Blocks[3].Text.Text, want)
+	}
+	if want := "<https://my-company.atlassian.net/wiki/spaces/SRE/pages/1|Full report>"; message.Blocks[5].Elements[0].Text != want {
+		t.Errorf("got link %q, want %q", message.Blocks[5].Elements[0].Text, want)
+	}
+}
+
+func TestPostSlackBotToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		postMessage   string
+		getPermalink  string
+		wantPermalink string
+		wantErr       string
+	}{
+		{
+			name:          "posted",
+			postMessage:   `{"ok": true, "channel": "C123", "ts": "1626775200.000100"}`,
+			getPermalink:  `{"ok": true, "channel": "C123", "permalink": "https://my-company.slack.com/archives/C123/p1626775200000100"}`,
+			wantPermalink: "https://my-company.slack.com/archives/C123/p1626775200000100",
+		},
+		{
+			name:        "not posted",
+			postMessage: `{"ok": false, "error": "channel_not_found"}`,
+			wantErr:     "failed to post to Slack: channel_not_found",
+		},
+		{
+			name:         "no permalink",
+			postMessage:  `{"ok": true, "channel": "C123", "ts": "1626775200.000100"}`,
+			getPermalink: `{"ok": false, "error": "message_not_found"}`,
+			wantErr:      "posted to Slack, but failed to get the link to the message: message_not_found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer xoxb-token" {
+					t.Errorf("unexpected Authorization header %q", r.Header.Get("Authorization"))
+				}
+				w.Header().Set("Content-Type", "application/json")
+				switch r.URL.Path {
+				case "/api/chat.postMessage":
+					var message slackMessage
+					if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+						t.Errorf("error decoding message: %v", err)
+					}
+					if message.Channel != "#my-team" {
+						t.Errorf("got channel %q, want #my-team", message.Channel)
+					}
+					w.Write([]byte(tt.postMessage))
+				case "/api/chat.getPermalink":
+					if tt.getPermalink == "" {
+						t.Errorf("unexpected request %s", r.URL)
+					}
+					if r.URL.Query().Get("channel") != "C123" || r.URL.Query().Get("message_ts") != "1626775200.000100" {
+						t.Errorf("unexpected query %s", r.URL.RawQuery)
+					}
+					w.Write([]byte(tt.getPermalink))
+				default:
+					t.Errorf("unexpected request %s", r.URL)
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+
+			permalink, err := PostSlackMessage(context.Background(), SlackRequest{
+				Token:   "xoxb-token",
+				Channel: "#my-team",
+				ApiURL:  server.URL + "/api/",
+				Report:  goldenReport(),
+			})
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PostSlackMessage: %v", err)
+			}
+			if permalink != tt.wantPermalink {
+				t.Errorf("got permalink %q, want %q", permalink, tt.wantPermalink)
+			}
+		})
+	}
+}
+
+func TestSlackBlocksLimits(t *testing.T) {
+	r := goldenReport()
+	r.Title = strings.Repeat("Very long title ", 20)
+	for i := 0; i < 60; i++ {
+		r.Incidents = append(r.Incidents, &Incident{ID: fmt.Sprintf("#incident-%d", i+3), Title: "More <trouble> & more"})
+	}
+
+	blocks := slackBlocks(r, "https://my-company.atlassian.net/wiki/spaces/SRE/pages/1")
+	if len(blocks) != slackMaxBlocks {
+		t.Errorf("got %d blocks, want %d", len(blocks), slackMaxBlocks)
+	}
+
+	header := blocks[0].Text.Text
+	if utf8.RuneCountInString(header) != slackMaxHeaderLength || !strings.HasSuffix(header, "…") {
+		t.Errorf("got header of %d characters %q, want %d ending with an ellipsis", utf8.RuneCountInString(header), header, slackMaxHeaderLength)
+	}
+	if !strings.Contains(blocks[5].Text.Text, "More &lt;trouble&gt; &amp; more") {
+		t.Errorf("got incident %q, want its title escaped", blocks[5].Text.Text)
+	}
+	// Header, totals and divider, then 45 incidents, the note about the others and the link to the report
+	if want := "and 17 more incidents"; blocks[len(blocks)-2].Elements[0].Text != want {
+		t.Errorf("got note %q, want %q", blocks[len(blocks)-2].Elements[0].Text, want)
+	}
+}
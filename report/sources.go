@@ -0,0 +1,20 @@
+package report
+
+import (
+	"context"
+	"time"
+)
+
+// IncidentSource fetches the incidents a report is built from
+type IncidentSource interface {
+	// FetchIncidents returns the incidents declared between since and until, sorted by creation time.
+	// If some incidents could only be partially fetched, it returns them along with a *PartialDataError.
+	FetchIncidents(ctx context.Context, since, until time.Time) ([]*Incident, error)
+}
+
+// PageSource fetches the pages a report is built from
+type PageSource interface {
+	// FetchPages returns the pages that fired between since and until, sorted by creation time.
+	// If some pages could only be partially fetched, it returns them along with a *PartialDataError.
+	FetchPages(ctx context.Context, since, until time.Time) ([]*Page, error)
+}
@@ -0,0 +1,84 @@
+package report
+
+import (
+	_ "embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	filloutPlaceholder = "  _TODO: please fill out_"
+)
+
+// DefaultTemplate is the text/template used to render reports in markdown.
+// It is a good starting point for custom templates.
+//
+//go:embed templates/report.md.tmpl
+var DefaultTemplate string
+
+var defaultTemplate = template.Must(newTemplate().Parse(DefaultTemplate))
+
+// templateFuncs are the functions available to report templates, on top of the text/template builtins
+var templateFuncs = template.FuncMap{
+	// link renders a markdown link
+	"link": link,
+	// time formats a time in the local time zone, e.g. "2021-07-14 @15:04:05"
+	"time": func(t time.Time) string {
+		return t.Local().Format("2006-01-02 @15:04:05")
+	},
+	// join joins strings with a separator
+	"join": strings.Join,
+	// correlation describes how a page was explicitly linked to the incident with the given ID, empty if it fired during the incident
+	"correlation": correlationNote,
+	// placeholder is the text asking the team to fill out a section
+	"placeholder": func() string {
+		return filloutPlaceholder
+	},
+}
+
+// htmlTemplateFuncs returns the functions available to the HTML and Confluence storage templates: those of markdown templates,
+// and markdown to render free text as HTML. Each format adds its own functions on top.
+func htmlTemplateFuncs() htmltemplate.FuncMap {
+	funcs := htmltemplate.FuncMap{
+		// markdown renders free text such as incident summaries, which often contain markdown.
+		// goldmark escapes raw HTML by default, so its output is safe to embed as is.
+		"markdown": func(s string) (htmltemplate.HTML, error) {
+			html, err := convertMarkdown(s)
+			return htmltemplate.HTML(html), err
+		},
+	}
+	for name, fn := range templateFuncs {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+func newTemplate() *template.Template {
+	return template.New("report").Funcs(templateFuncs)
+}
+
+// Markdown renders the report with the built-in markdown template
+func (r *Report) Markdown() (string, error) {
+	var b strings.Builder
+	if err := defaultTemplate.Execute(&b, r); err != nil {
+		return "", fmt.Errorf("error rendering the built-in markdown template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// RenderTemplate renders the report through a custom text/template, which has access to the same functions as DefaultTemplate
+func (r *Report) RenderTemplate(text string) (string, error) {
+	t, err := newTemplate().Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, r); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
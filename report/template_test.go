@@ -0,0 +1,116 @@
+package report
+
+import (
+	"testing"
+	"time"
+)
+
+// goldenReport is a report exercising every section of the built-in markdown template
+func goldenReport() *Report {
+	t0 := time.Date(2021, 7, 20, 10, 0, 0, 0, time.UTC)
+	checkout := &Page{Title: "Checkout errors [prod]", Link: "https://acme.pagerduty.com/incidents/Q1", CreatedAt: t0.Add(-5 * time.Minute), IncidentIDs: []string{"#incident-1"},
+		Responders: []string{"oncall@example.com"}, Notes: []PageNote{{Content: "Rolling back", UserName: "On Call", UserEmail: "oncall@example.com"}}}
+	latency := &Page{Title: "Checkout latency", Link: "https://acme.pagerduty.com/incidents/Q2", CreatedAt: t0.Add(10 * time.Minute), IncidentIDs: []string{"#incident-1"}}
+	disk := &Page{Title: "Disk full", Link: "https://acme.pagerduty.com/incidents/Q3", CreatedAt: t0.Add(26 * time.Hour),
+		Responders: []string{"oncall@example.com", "db@example.com"}, Notes: []PageNote{{Content: "Cleaned up /tmp", UserEmail: "db@example.com"}, {Content: "Again"}}}
+	cert := &Page{Title: "Certificate expiring", Link: "https://acme.pagerduty.com/incidents/Q4", CreatedAt: t0.Add(50 * time.Hour)}
+
+	return &Report{
+		Title: "My-Team On-Call Report 2021-07-27",
+		Teams: []string{"my-team"},
+		Since: "2021-07-14",
+		Until: "2021-07-27",
+		Incidents: []*Incident{
+			{
+				ID:                     "#incident-1",
+				Title:                  "Checkout is down",
+				Link:                   "https://app.datadoghq.com/incidents/1",
+				Severity:               "SEV-2",
+				Commander:              "Ina Commander",
+				CommanderEmail:         "ic@example.com",
+				RootCause:              "Bad deploy",
+				Summary:                "Rolled back the deploy",
+				CustomerImpactScope:    "All checkouts failed",
+				CustomerImpactDuration: 90 * time.Second,
+				CreatedAt:              t0,
+				ResolvedAt:             t0.Add(time.Hour),
+				Pages:                  []*Page{checkout, latency},
+			},
+			{
+				ID:        "#incident-2",
+				Title:     "Search is slow",
+				Link:      "https://app.datadoghq.com/incidents/2",
+				Severity:  "SEV-3",
+				CreatedAt: t0.Add(24 * time.Hour),
+			},
+		},
+		OtherPages:     []*Page{disk, cert},
+		TotalIncidents: 2,
+		TotalPages:     4,
+		DataIssues: []DataIssue{
+			{Subject: "Disk full", Link: "https://acme.pagerduty.com/incidents/Q3", Problem: "could not fetch notes: boom"},
+			{Subject: "Datadog incident search", Problem: "could not fetch incidents past the first 50"},
+		},
+	}
+}
+
+// renderMarkdown renders the report with the built-in markdown template
+func renderMarkdown(t *testing.T, r *Report) string {
+	t.Helper()
+	markdown, err := r.Markdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return markdown
+}
+
+// withUTC renders local times in UTC for the duration of a test, so that golden files don't depend on the machine
+func withUTC(t *testing.T) {
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+}
+
+// TestMarkdown checks that the built-in template renders reports exactly like the markdown builder it replaced,
+// which uploads and merging previous reports rely on
+func TestMarkdown(t *testing.T) {
+	withUTC(t)
+	assertGolden(t, "report.md", []byte(renderMarkdown(t, goldenReport())))
+}
+
+func TestRenderTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "functions",
+			text: `{{ range .Incidents }}{{ link .ID .Link }} {{ time .CreatedAt }}{{ "\n" }}{{ end }}{{ join .Teams ", " }}{{ placeholder }}`,
+			want: "[#incident-1](https://app.datadoghq.com/incidents/1) 2021-07-20 @10:00:00\n[#incident-2](https://app.datadoghq.com/incidents/2) 2021-07-21 @10:00:00\nmy-team  _TODO: please fill out_",
+		},
+		{
+			name:    "syntax error",
+			text:    `{{ range .Incidents }}`,
+			wantErr: true,
+		},
+		{
+			name:    "execution error",
+			text:    `{{ .Missing }}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withUTC(t)
+			got, err := goldenReport().RenderTemplate(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want one: %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
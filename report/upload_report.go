@@ -2,11 +2,12 @@ package report
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
+	"net/url"
 	"regexp"
 	"time"
 
@@ -17,8 +18,9 @@ import (
 	"github.com/yuin/goldmark/renderer/html"
 )
 
-// confluencePage represents the JSON payload to create a new Confluence page
+// confluencePage represents the JSON payload to create or update a Confluence page
 type confluencePage struct {
+	ID    string `json:"id,omitempty"`
 	Type  string `json:"type"`
 	Title string `json:"title"`
 	Space struct {
@@ -33,6 +35,22 @@ type confluencePage struct {
 			Representation string `json:"representation"`
 		} `json:"storage"`
 	} `json:"body"`
+	// Version must be set to the next version number when updating a page
+	Version *confluenceVersion `json:"version,omitempty"`
+}
+
+type confluenceVersion struct {
+	Number int `json:"number"`
+}
+
+// confluenceContent is an existing Confluence page, as returned by the content API
+type confluenceContent struct {
+	ID        string            `json:"id"`
+	Title     string            `json:"title"`
+	Version   confluenceVersion `json:"version"`
+	Ancestors []struct {
+		ID string `json:"id"`
+	} `json:"ancestors"`
 }
 
 type UploadRequest struct {
@@ -41,7 +59,14 @@ type UploadRequest struct {
 	ConfluenceToken     string
 	SpaceKey            string
 	ParentId            string
-	MarkdownContent     string
+	// Id of the page to update. If empty, the page with the same title under ParentId is updated, or a new page is created
+	PageId          string
+	MarkdownContent string
+	// Report to render natively in Confluence storage format, instead of converting MarkdownContent
+	Report *Report
+	// Confluence account ids by email, to mention the incident commanders and responders of a natively rendered Report.
+	// Confluence Cloud doesn't let users be searched by email, see ReadConfluenceAccountIds.
+	AccountIDs map[string]string
 }
 
 // pruneMarkdownTitle removes the title header from the markdown, if found.
@@ -85,12 +110,22 @@ func convertMarkdown(s string) (string, error) {
 	return buf.String(), nil
 }
 
-// Upload creates a new Confluence page with the given details
+// Upload creates a new Confluence page with the given details, or updates the existing page with the same title
 func Upload(request UploadRequest) error {
-	content, title := pruneMarkdownTitle(request.MarkdownContent)
-	content, err := convertMarkdown(content)
+	return UploadContext(context.Background(), request)
+}
+
+// UploadContext is like Upload, but aborts the upload as soon as ctx is done.
+func UploadContext(ctx context.Context, request UploadRequest) error {
+	_, err := UploadPage(ctx, request)
+	return err
+}
+
+// UploadPage is like UploadContext, and returns the URL of the uploaded page so that it can be shared
+func UploadPage(ctx context.Context, request UploadRequest) (string, error) {
+	content, title, err := renderConfluencePage(request)
 	if err != nil {
-		return fmt.Errorf("error converting markdown: %v", err)
+		return "", err
 	}
 
 	// Try to come up with some title if we couldn't parse one
@@ -114,34 +149,141 @@ func Upload(request UploadRequest) error {
 	newPage.Body.Storage.Value = content
 	newPage.Body.Storage.Representation = "storage"
 
+	existing, err := findConfluencePage(ctx, baseURL, request, title)
+	if err != nil {
+		return "", err
+	}
+
+	method, pageURL := http.MethodPost, baseURL
+	if existing != nil {
+		// Updating a page creates a new version of it, numbered after the current one
+		newPage.ID = existing.ID
+		newPage.Version = &confluenceVersion{Number: existing.Version.Number + 1}
+		method, pageURL = http.MethodPut, baseURL+"/"+existing.ID
+	}
+
 	pageData, err := json.Marshal(newPage)
 	if err != nil {
-		return fmt.Errorf("error marshalling json: %v", err)
+		return "", fmt.Errorf("error marshalling json: %v", err)
 	}
 
-	httpReq, err := http.NewRequest("POST", baseURL, bytes.NewBuffer(pageData))
+	var uploaded struct {
+		Links struct {
+			Base  string `json:"base"`
+			WebUI string `json:"webui"`
+		} `json:"_links"`
+	}
+	err = doJSON(ctx, nil, func(ctx context.Context) (*http.Request, error) {
+		httpReq, err := http.NewRequestWithContext(ctx, method, pageURL, bytes.NewReader(pageData))
+		if err != nil {
+			return nil, err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.SetBasicAuth(request.ConfluenceUsername, request.ConfluenceToken)
+		return httpReq, nil
+	}, &uploaded)
 	if err != nil {
-		return fmt.Errorf("error creating request: %v", err)
+		if existing != nil {
+			return "", fmt.Errorf("failed to update page %s: %w", existing.ID, err)
+		}
+		return "", fmt.Errorf("failed to create page: %w", err)
 	}
+	return uploaded.Links.Base + uploaded.Links.WebUI, nil
+}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.SetBasicAuth(request.ConfluenceUsername, request.ConfluenceToken)
+// renderConfluencePage renders the content and title of the page, either natively from the report or by converting the markdown
+func renderConfluencePage(request UploadRequest) (string, string, error) {
+	if request.Report == nil {
+		content, title := pruneMarkdownTitle(request.MarkdownContent)
+		content, err := convertMarkdown(content)
+		if err != nil {
+			return "", "", fmt.Errorf("error converting markdown: %v", err)
+		}
+		return content, title, nil
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(httpReq)
+	content, err := request.Report.ConfluenceStorage(request.AccountIDs)
 	if err != nil {
-		return fmt.Errorf("error making request: %v", err)
+		return "", "", fmt.Errorf("error rendering report: %v", err)
 	}
-	defer resp.Body.Close()
+	return content, request.Report.Title, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("error reading response body: %v", err)
+// findConfluencePage returns the page the report should be uploaded to, or nil if a new page should be created.
+// This is the page with the given id if the request has one, otherwise the page with the same title under the parent page.
+func findConfluencePage(ctx context.Context, baseURL string, request UploadRequest, title string) (*confluenceContent, error) {
+	header := confluenceHeader(request)
+
+	if request.PageId != "" {
+		var page confluenceContent
+		if err := getJSON(ctx, nil, baseURL+"/"+url.PathEscape(request.PageId)+"?expand=version", header, &page); err != nil {
+			return nil, fmt.Errorf("failed to get page %s: %w", request.PageId, err)
+		}
+		return &page, nil
+	}
+
+	params := url.Values{}
+	params.Set("spaceKey", request.SpaceKey)
+	params.Set("title", title)
+	params.Set("type", "page")
+	params.Set("expand", "version,ancestors")
+
+	var response struct {
+		Results []confluenceContent `json:"results"`
+	}
+	if err := getJSON(ctx, nil, baseURL+"?"+params.Encode(), header, &response); err != nil {
+		return nil, fmt.Errorf("failed to search for page %q: %w", title, err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Fprintf(os.Stderr, "Error response body: %s", string(body))
-		return fmt.Errorf("failed to create page, status code: %d", resp.StatusCode)
+	for i, page := range response.Results {
+		if request.ParentId == "" {
+			return &response.Results[i], nil
+		}
+		// The last ancestor is the direct parent of the page
+		if n := len(page.Ancestors); n > 0 && page.Ancestors[n-1].ID == request.ParentId {
+			return &response.Results[i], nil
+		}
 	}
-	return nil
+
+	// Titles are unique within a space, so creating the page would fail
+	if len(response.Results) > 0 {
+		return nil, fmt.Errorf("page %q already exists in space %s outside of parent %s, set its page id to update it", title, request.SpaceKey, request.ParentId)
+	}
+	return nil, nil
+}
+
+// DownloadMarkdown fetches the Confluence page a report with the given title would be uploaded to, and converts it back to markdown,
+// so that the sections filled out by the team can be merged into the new report with MergeMarkdown.
+// It returns an empty string if the page doesn't exist yet.
+func DownloadMarkdown(ctx context.Context, request UploadRequest, title string) (string, error) {
+	baseURL := fmt.Sprintf("https://%s.atlassian.net/wiki/rest/api/content", request.ConfluenceSubdomain)
+	existing, err := findConfluencePage(ctx, baseURL, request, title)
+	if err != nil || existing == nil {
+		return "", err
+	}
+
+	header := confluenceHeader(request)
+
+	var page struct {
+		Body struct {
+			Storage struct {
+				Value string `json:"value"`
+			} `json:"storage"`
+		} `json:"body"`
+	}
+	if err := getJSON(ctx, nil, baseURL+"/"+url.PathEscape(existing.ID)+"?expand=body.storage", header, &page); err != nil {
+		return "", fmt.Errorf("failed to get page %s: %w", existing.ID, err)
+	}
+
+	markdown, err := storageToMarkdown(page.Body.Storage.Value)
+	if err != nil {
+		return "", fmt.Errorf("error reading page %s: %v", existing.ID, err)
+	}
+	return markdown, nil
+}
+
+func confluenceHeader(request UploadRequest) http.Header {
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(request.ConfluenceUsername+":"+request.ConfluenceToken)))
+	return header
 }
@@ -0,0 +1,138 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// withRedirectedDefaultClient sends the requests of the default HTTP client to server for the duration of a test
+func withRedirectedDefaultClient(t *testing.T, server *httptest.Server) {
+	target, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := http.DefaultClient
+	http.DefaultClient = &http.Client{Transport: redirectTransport{target: target}}
+	t.Cleanup(func() { http.DefaultClient = client })
+}
+
+func TestUploadPage(t *testing.T) {
+	tests := []struct {
+		name string
+		// Page id set in the request
+		pageID string
+		// Pages found by title, as returned by the content API
+		found      string
+		wantMethod string
+		wantPath   string
+		// Version the page is uploaded with, 0 when creating it
+		wantVersion int
+	}{
+		{
+			name:       "new page",
+			found:      `[]`,
+			wantMethod: http.MethodPost,
+			wantPath:   "/wiki/rest/api/content",
+		},
+		{
+			name:        "existing page under the parent",
+			found:       `[{"id": "41", "title": "My Report", "version": {"number": 1}, "ancestors": [{"id": "7"}]}, {"id": "42", "title": "My Report", "version": {"number": 3}, "ancestors": [{"id": "1"}, {"id": "10"}]}]`,
+			wantMethod:  http.MethodPut,
+			wantPath:    "/wiki/rest/api/content/42",
+			wantVersion: 4,
+		},
+		{
+			name:        "page id",
+			pageID:      "99",
+			wantMethod:  http.MethodPut,
+			wantPath:    "/wiki/rest/api/content/99",
+			wantVersion: 8,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var uploads []string
+			var uploaded confluencePage
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if user, token, ok := r.BasicAuth(); !ok || user != "me@example.com" || token != "token" {
+					t.Errorf("unexpected credentials for %s", r.URL)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/wiki/rest/api/content":
+					if q := r.URL.Query(); q.Get("spaceKey") != "OPS" || q.Get("title") != "My Report" {
+						t.Errorf("unexpected search %s", r.URL.RawQuery)
+					}
+					w.Write([]byte(`{"results": ` + tt.found + `}`))
+				case r.Method == http.MethodGet && r.URL.Path == "/wiki/rest/api/content/99":
+					w.Write([]byte(`{"id": "99", "title": "Renamed", "version": {"number": 7}}`))
+				case r.Method == http.MethodPost || r.Method == http.MethodPut:
+					uploads = append(uploads, r.Method+" "+r.URL.Path)
+					if err := json.NewDecoder(r.Body).Decode(&uploaded); err != nil {
+						t.Error(err)
+					}
+					w.Write([]byte(`{"_links": {"base": "https://acme.atlassian.net/wiki", "webui": "/spaces/OPS/pages/42"}}`))
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL)
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+			withRedirectedDefaultClient(t, server)
+
+			link, err := UploadPage(context.Background(), UploadRequest{
+				ConfluenceSubdomain: "acme",
+				ConfluenceUsername:  "me@example.com",
+				ConfluenceToken:     "token",
+				SpaceKey:            "OPS",
+				ParentId:            "10",
+				PageId:              tt.pageID,
+				MarkdownContent:     "---\ntitle: My Report\n---\n# Incidents\n",
+			})
+			if err != nil {
+				t.Fatalf("UploadPage: %v", err)
+			}
+			if link != "https://acme.atlassian.net/wiki/spaces/OPS/pages/42" {
+				t.Errorf("got link %s", link)
+			}
+
+			// The page is uploaded once, updating the existing page rather than creating a duplicate
+			if want := tt.wantMethod + " " + tt.wantPath; len(uploads) != 1 || uploads[0] != want {
+				t.Fatalf("got uploads %v, want %s", uploads, want)
+			}
+			gotVersion := 0
+			if uploaded.Version != nil {
+				gotVersion = uploaded.Version.Number
+			}
+			if gotVersion != tt.wantVersion || uploaded.Title != "My Report" || uploaded.Body.Storage.Value != `<h1 id="incidents">Incidents</h1>`+"\n" {
+				t.Errorf("got page %+v, want version %d", uploaded, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestUploadPageTitleTakenOutsideParent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"results": [{"id": "41", "title": "My Report", "version": {"number": 1}, "ancestors": [{"id": "7"}]}]}`))
+	}))
+	defer server.Close()
+	withRedirectedDefaultClient(t, server)
+
+	_, err := UploadPage(context.Background(), UploadRequest{
+		ConfluenceSubdomain: "acme",
+		SpaceKey:            "OPS",
+		ParentId:            "10",
+		MarkdownContent:     "---\ntitle: My Report\n---\n# Incidents\n",
+	})
+	if err == nil {
+		t.Error("expected an error, the title is taken in the space")
+	}
+}
@@ -0,0 +1,183 @@
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/PagerDuty/go-pagerduty"
+)
+
+const (
+	// defaultUserCacheTTL is how long an on-disk user cache is trusted before it is refreshed
+	defaultUserCacheTTL = 24 * time.Hour
+)
+
+// userCacheNow tells the time the age of the user cache is measured against, tests replace it with a fake clock
+var userCacheNow = time.Now
+
+type pagerdutyUser struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// userEntry holds a single user lookup, so that concurrent lookups of the same user only hit the API once
+type userEntry struct {
+	once sync.Once
+	user pagerdutyUser
+	err  error
+}
+
+// userCache is the on-disk representation of the user directory
+type userCache struct {
+	UpdatedAt time.Time                `json:"updated_at"`
+	Users     map[string]pagerdutyUser `json:"users"`
+}
+
+// userDirectory resolves PagerDuty user IDs to names and emails.
+// Each user is fetched at most once per run, and the directory can be persisted across runs with an on-disk cache.
+type userDirectory struct {
+	client    *pagerduty.Client
+	cachePath string
+	updatedAt time.Time
+
+	mu    sync.Mutex
+	users map[string]*userEntry
+}
+
+// newUserDirectory creates a user directory backed by the given client.
+// If cachePath is set, users are loaded from it when it is younger than ttl, otherwise all users are listed in bulk and the cache is refreshed.
+func newUserDirectory(ctx context.Context, client *pagerduty.Client, cachePath string, ttl time.Duration) *userDirectory {
+	d := &userDirectory{
+		client:    client,
+		cachePath: cachePath,
+		users:     make(map[string]*userEntry),
+	}
+	if cachePath == "" {
+		return d
+	}
+	if ttl <= 0 {
+		ttl = defaultUserCacheTTL
+	}
+
+	cache, err := readUserCache(cachePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Could not read user cache %s, ignoring: %v\n", cachePath, err)
+	}
+	if err == nil && userCacheNow().Sub(cache.UpdatedAt) < ttl {
+		d.updatedAt = cache.UpdatedAt
+		for id, u := range cache.Users {
+			d.add(id, u)
+		}
+		return d
+	}
+
+	if err := d.loadAll(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not list users, falling back to individual lookups: %v\n", err)
+	}
+	return d
+}
+
+// get returns the user with the given ID, fetching it from PagerDuty if it isn't known yet
+func (d *userDirectory) get(ctx context.Context, id string) (pagerdutyUser, error) {
+	d.mu.Lock()
+	e, ok := d.users[id]
+	if !ok {
+		e = &userEntry{}
+		d.users[id] = e
+	}
+	d.mu.Unlock()
+
+	e.once.Do(func() {
+		var u *pagerduty.User
+		e.err = defaultRetryPolicy.do(ctx, func(ctx context.Context) (err error) {
+			u, err = d.client.GetUserWithContext(ctx, id, pagerduty.GetUserOptions{})
+			return err
+		})
+		if e.err == nil {
+			e.user = pagerdutyUser{Name: u.Name, Email: u.Email}
+		}
+	})
+	return e.user, e.err
+}
+
+func (d *userDirectory) add(id string, u pagerdutyUser) {
+	e := &userEntry{user: u}
+	e.once.Do(func() {})
+
+	d.mu.Lock()
+	d.users[id] = e
+	d.mu.Unlock()
+}
+
+// loadAll lists every user of the account in bulk
+func (d *userDirectory) loadAll(ctx context.Context) error {
+	var offset uint
+	for {
+		var response *pagerduty.ListUsersResponse
+		err := defaultRetryPolicy.do(ctx, func(ctx context.Context) (err error) {
+			response, err = d.client.ListUsersWithContext(ctx, pagerduty.ListUsersOptions{
+				Offset: offset,
+				Limit:  pagerdutyPageLimit,
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, u := range response.Users {
+			d.add(u.ID, pagerdutyUser{Name: u.Name, Email: u.Email})
+		}
+		if !response.More || len(response.Users) == 0 {
+			break
+		}
+		offset += uint(len(response.Users))
+	}
+	d.updatedAt = userCacheNow()
+	return nil
+}
+
+// save writes all successfully resolved users to the on-disk cache, if one is configured
+func (d *userDirectory) save() error {
+	if d.cachePath == "" {
+		return nil
+	}
+
+	cache := userCache{
+		UpdatedAt: d.updatedAt,
+		Users:     make(map[string]pagerdutyUser),
+	}
+	// Users resolved one by one don't make the cache any fresher
+	if cache.UpdatedAt.IsZero() {
+		cache.UpdatedAt = time.Unix(0, 0)
+	}
+
+	d.mu.Lock()
+	for id, e := range d.users {
+		if e.err == nil {
+			cache.Users[id] = e.user
+		}
+	}
+	d.mu.Unlock()
+
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(d.cachePath, data, 0o600)
+}
+
+func readUserCache(path string) (userCache, error) {
+	var cache userCache
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cache, err
+	}
+	err = json.Unmarshal(data, &cache)
+	return cache, err
+}
@@ -0,0 +1,121 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PagerDuty/go-pagerduty"
+)
+
+// fakePagerDutyUsers serves the PagerDuty users API, counting bulk listings and individual lookups
+type fakePagerDutyUsers struct {
+	lists   int32
+	lookups int32
+}
+
+func (f *fakePagerDutyUsers) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.URL.Path == "/users":
+		atomic.AddInt32(&f.lists, 1)
+		w.Write([]byte(`{"users": [{"id": "U1", "name": "First", "email": "first@example.com"}, {"id": "U2", "name": "Second", "email": "second@example.com"}], "more": false}`))
+	case strings.HasPrefix(r.URL.Path, "/users/"):
+		atomic.AddInt32(&f.lookups, 1)
+		id := strings.TrimPrefix(r.URL.Path, "/users/")
+		fmt.Fprintf(w, `{"user": {"id": %q, "name": "User %s", "email": "%s@example.com"}}`, id, id, strings.ToLower(id))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// withFakeClock makes the user cache age against the returned clock for the duration of a test
+func withFakeClock(t *testing.T, now time.Time) *time.Time {
+	clock := now
+	userCacheNow = func() time.Time { return clock }
+	t.Cleanup(func() { userCacheNow = time.Now })
+	return &clock
+}
+
+func TestUserDirectoryCache(t *testing.T) {
+	fake := &fakePagerDutyUsers{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client := pagerduty.NewClient("token", pagerduty.WithAPIEndpoint(server.URL))
+	ctx := context.Background()
+
+	clock := withFakeClock(t, time.Date(2021, 7, 27, 10, 0, 0, 0, time.UTC))
+	path := filepath.Join(t.TempDir(), "users.json")
+
+	// Without a cache, users are listed in bulk and saved
+	d := newUserDirectory(ctx, client, path, time.Hour)
+	if u, err := d.get(ctx, "U1"); err != nil || u.Email != "first@example.com" {
+		t.Errorf("got %+v, %v, want first@example.com", u, err)
+	}
+	if err := d.save(); err != nil {
+		t.Fatal(err)
+	}
+	if fake.lists != 1 || fake.lookups != 0 {
+		t.Fatalf("got %d listings and %d lookups, want 1 and 0", fake.lists, fake.lookups)
+	}
+
+	// Within the TTL, users come from the cache, and unknown users are looked up
+	*clock = clock.Add(59 * time.Minute)
+	d = newUserDirectory(ctx, client, path, time.Hour)
+	if u, err := d.get(ctx, "U2"); err != nil || u.Name != "Second" {
+		t.Errorf("got %+v, %v, want Second", u, err)
+	}
+	if u, err := d.get(ctx, "U3"); err != nil || u.Email != "u3@example.com" {
+		t.Errorf("got %+v, %v, want u3@example.com", u, err)
+	}
+	if fake.lists != 1 || fake.lookups != 1 {
+		t.Fatalf("got %d listings and %d lookups, want 1 and 1", fake.lists, fake.lookups)
+	}
+	// Users looked up one by one don't make the cache any fresher
+	if err := d.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once expired, users are listed again
+	*clock = clock.Add(2 * time.Minute)
+	d = newUserDirectory(ctx, client, path, time.Hour)
+	if u, err := d.get(ctx, "U1"); err != nil || u.Name != "First" {
+		t.Errorf("got %+v, %v, want First", u, err)
+	}
+	if fake.lists != 2 {
+		t.Errorf("got %d listings, want 2", fake.lists)
+	}
+}
+
+func TestUserDirectoryConcurrentLookups(t *testing.T) {
+	fake := &fakePagerDutyUsers{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client := pagerduty.NewClient("token", pagerduty.WithAPIEndpoint(server.URL))
+	ctx := context.Background()
+
+	// Without a cache path, users are only looked up one by one
+	d := newUserDirectory(ctx, client, "", 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if u, err := d.get(ctx, "U7"); err != nil || u.Email != "u7@example.com" {
+				t.Errorf("got %+v, %v, want u7@example.com", u, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if fake.lists != 0 || fake.lookups != 1 {
+		t.Errorf("got %d listings and %d lookups, want 0 and 1", fake.lists, fake.lookups)
+	}
+}